@@ -0,0 +1,166 @@
+package mo
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"sort"
+
+	"github.com/xsuners/mo/log"
+	"github.com/xsuners/mo/net/description"
+	"github.com/xsuners/mo/net/xnats"
+	"go.uber.org/zap"
+)
+
+// admin serves the following endpoints of an app:
+//
+//	/healthz       200 as long as the process is alive
+//	/readyz        200 only when the app is serving, 503 otherwise
+//	/buildinfo     the output of BuildInfo()
+//	/services      registered services of every server in json
+//	/debug/pprof/  the runtime profiling data
+type admin struct {
+	app  *app
+	port int
+	hs   *http.Server
+}
+
+func newAdmin(a *app, port int) *admin {
+	ad := &admin{
+		app:  a,
+		port: port,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", ad.healthz)
+	mux.HandleFunc("/readyz", ad.readyz)
+	mux.HandleFunc("/buildinfo", ad.buildinfo)
+	mux.HandleFunc("/services", ad.services)
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	ad.hs = &http.Server{Handler: mux}
+	return ad
+}
+
+func (ad *admin) start() error {
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", ad.port))
+	if err != nil {
+		return err
+	}
+	ad.port = lis.Addr().(*net.TCPAddr).Port
+	log.Infof("mo: admin listening on %s", lis.Addr().String())
+	go func() {
+		if err := ad.hs.Serve(lis); err != nil && err != http.ErrServerClosed {
+			log.Errors("mo: admin serve error", zap.Error(err))
+		}
+	}()
+	return nil
+}
+
+func (ad *admin) stop() {
+	if err := ad.hs.Close(); err != nil {
+		log.Errors("mo: admin close error", zap.Error(err))
+	}
+}
+
+func (ad *admin) healthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+func (ad *admin) readyz(w http.ResponseWriter, r *http.Request) {
+	st := ad.app.getState()
+	if st != stateServing {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	fmt.Fprintln(w, st.String())
+}
+
+func (ad *admin) buildinfo(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, BuildInfo())
+}
+
+type adminMethod struct {
+	Name        string `json:"name"`
+	Input       string `json:"input,omitempty"`
+	Output      string `json:"output,omitempty"`
+	Subject     string `json:"subject,omitempty"`
+	Broadcast   bool   `json:"broadcast,omitempty"`
+	Cron        string `json:"cron,omitempty"`
+	CheckLeader bool   `json:"check_leader,omitempty"`
+}
+
+type adminService struct {
+	Name    string        `json:"name"`
+	Methods []adminMethod `json:"methods"`
+	Streams []string      `json:"streams,omitempty"`
+}
+
+type adminServer struct {
+	Name      string         `json:"name,omitempty"`
+	Transport string         `json:"transport"`
+	Services  []adminService `json:"services"`
+}
+
+func (ad *admin) services(w http.ResponseWriter, r *http.Request) {
+	var out []adminServer
+	for _, s := range ad.app.servers {
+		as := adminServer{
+			Name:      s.name,
+			Transport: s.kind,
+		}
+		if l, ok := s.server.(description.Lister); ok {
+			as.Services = listServices(l.Services())
+		}
+		if ns, ok := s.server.(*xnats.Server); ok {
+			for i := range as.Services {
+				svc := &as.Services[i]
+				for j := range svc.Methods {
+					svc.Methods[j].Subject = ns.Subject(svc.Name, svc.Methods[j].Name)
+				}
+			}
+		}
+		out = append(out, as)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(out); err != nil {
+		log.Errors("mo: admin encode services error", zap.Error(err))
+	}
+}
+
+func listServices(services map[string]*description.ServiceInfo) (out []adminService) {
+	out = make([]adminService, 0, len(services))
+	for name, info := range services {
+		as := adminService{
+			Name:    name,
+			Methods: make([]adminMethod, 0, len(info.Methods())),
+		}
+		for _, m := range info.Methods() {
+			as.Methods = append(as.Methods, adminMethod{
+				Name:        m.MethodName,
+				Input:       m.Input,
+				Output:      m.Output,
+				Broadcast:   m.Broadcast,
+				Cron:        m.Cron,
+				CheckLeader: m.CheckLeader,
+			})
+		}
+		sort.Slice(as.Methods, func(i, j int) bool {
+			return as.Methods[i].Name < as.Methods[j].Name
+		})
+		for name := range info.Streams() {
+			as.Streams = append(as.Streams, name)
+		}
+		sort.Strings(as.Streams)
+		out = append(out, as)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+	return
+}
@@ -0,0 +1,46 @@
+package mo
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nats-io/nats-server/v2/test"
+	"github.com/xsuners/mo/net/xnats"
+	"github.com/xsuners/mo/net/xtcp"
+)
+
+func TestAdminServices(t *testing.T) {
+	ns := test.RunRandClientPortServer()
+	t.Cleanup(ns.Shutdown)
+
+	a := New(nil,
+		TCP(xtcp.Port(0)),
+		NATS(xnats.URLS(ns.ClientURL()), xnats.Subject("test.Ping/Ping", "test.ping.v2")),
+	).(*app)
+	nsrv := a.Server("xnats", "").(*xnats.Server)
+	nsrv.Register(struct{}{}, &pingDesc)
+	if err := nsrv.Serve(); err != nil {
+		t.Fatalf("Serve() error = %v", err)
+	}
+	defer nsrv.Stop()
+	a.Server("xtcp", "").Register(struct{}{}, &pingDesc)
+
+	w := httptest.NewRecorder()
+	newAdmin(a, 0).services(w, httptest.NewRequest("GET", "/services", nil))
+	var out []adminServer
+	if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+		t.Fatalf("Unmarshal() error = %v, body %s", err, w.Body)
+	}
+	subjects := map[string]string{}
+	for _, s := range out {
+		for _, svc := range s.Services {
+			for _, m := range svc.Methods {
+				subjects[s.Transport] = m.Subject
+			}
+		}
+	}
+	if subjects["xnats"] != "test.ping.v2" || subjects["xtcp"] != "" {
+		t.Errorf("subjects = %v, want the overridden one of xnats only", subjects)
+	}
+}
@@ -0,0 +1,198 @@
+package mo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/skyasker/go-flags"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is the prefix of the environment variables bound to the options,
+// e.g. MO_GRPC_PORT for the port option in the grpc group.
+const EnvPrefix = "MO_"
+
+// envDelim separates the values of the slice and map options in environment
+// variables.
+const envDelim = ";"
+
+// load parses the options in the following order, the latter ones override
+// the former ones:
+//
+//  1. the default values
+//  2. the config file, parsed as yaml, json or ini by its extension
+//  3. the environment variables, see EnvPrefix
+//  4. the command line flags
+//
+// The config file is optional if it is the default one and does not exist.
+func load(parser *flags.Parser, option Optioner, args []string) error {
+	// flags first for the config file path and version
+	if _, err := parser.ParseArgs(args); err != nil {
+		return err
+	}
+	if option.V() {
+		return nil
+	}
+	ini := flags.NewIniParser(parser)
+	if err := parseFile(ini, parser, option.C()); err != nil {
+		if !os.IsNotExist(err) || configSet(parser) {
+			return err
+		}
+	}
+	if err := ini.Parse(strings.NewReader(envIni(parser))); err != nil {
+		return fmt.Errorf("mo: parse environment variables error: %w", err)
+	}
+	_, err := parser.ParseArgs(args)
+	return err
+}
+
+// configSet reports whether the config file path is given explicitly.
+func configSet(parser *flags.Parser) bool {
+	if opt := parser.FindOptionByLongName("config"); opt != nil && opt.IsSet() && !opt.IsSetDefault() {
+		return true
+	}
+	_, ok := os.LookupEnv(EnvPrefix + "CONFIG")
+	return ok
+}
+
+func parseFile(ini *flags.IniParser, parser *flags.Parser, path string) error {
+	var (
+		v     map[string]interface{}
+		unmar func([]byte, interface{}) error
+	)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		unmar = yaml.Unmarshal
+	case ".json":
+		unmar = json.Unmarshal
+	default:
+		return ini.ParseFile(path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := unmar(data, &v); err != nil {
+		return fmt.Errorf("mo: parse config file %s error: %w", path, err)
+	}
+	if err := ini.Parse(strings.NewReader(treeIni(parser, v))); err != nil {
+		return fmt.Errorf("mo: parse config file %s error: %w", path, err)
+	}
+	return nil
+}
+
+// treeIni converts the decoded yaml or json document to ini, the mappings
+// named after option groups are sections and the others are values of map
+// options.
+func treeIni(parser *flags.Parser, v map[string]interface{}) string {
+	var global, sections bytes.Buffer
+	for _, key := range sortedKeys(v) {
+		if m, ok := v[key].(map[string]interface{}); ok && parser.Group.Find(key) != nil {
+			fmt.Fprintf(&sections, "[%s]\n", key)
+			for _, k := range sortedKeys(m) {
+				writeIni(&sections, k, m[k])
+			}
+			continue
+		}
+		writeIni(&global, key, v[key])
+	}
+	global.Write(sections.Bytes())
+	return global.String()
+}
+
+func writeIni(buf *bytes.Buffer, key string, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+	case []interface{}:
+		for _, e := range v {
+			writeIni(buf, key, e)
+		}
+	case map[string]interface{}:
+		for _, k := range sortedKeys(v) {
+			fmt.Fprintf(buf, "%s = %q\n", key, fmt.Sprintf("%s:%v", k, v[k]))
+		}
+	default:
+		fmt.Fprintf(buf, "%s = %q\n", key, fmt.Sprint(v))
+	}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// envIni converts the environment variables bound to the options to ini. An
+// option named maxConnections in group tcp is bound to MO_TCP_MAX_CONNECTIONS,
+// and the values of slice and map options are separated by semicolons.
+func envIni(parser *flags.Parser) string {
+	var buf bytes.Buffer
+	var walk func(g *flags.Group, section string)
+	walk = func(g *flags.Group, section string) {
+		var lines bytes.Buffer
+		for _, opt := range g.Options() {
+			name := iniName(opt)
+			key := EnvPrefix + envName(name)
+			if section != "" {
+				key = EnvPrefix + envName(section) + "_" + envName(name)
+			}
+			value, ok := os.LookupEnv(key)
+			if !ok {
+				continue
+			}
+			values := []string{value}
+			if k := opt.Field().Type.Kind(); k == reflect.Slice || k == reflect.Map {
+				values = strings.Split(value, envDelim)
+			}
+			for _, v := range values {
+				fmt.Fprintf(&lines, "%s = %q\n", name, v)
+			}
+		}
+		if lines.Len() > 0 {
+			if section != "" {
+				fmt.Fprintf(&buf, "[%s]\n", section)
+			}
+			buf.Write(lines.Bytes())
+		}
+		for _, sub := range g.Groups() {
+			walk(sub, sub.ShortDescription)
+		}
+	}
+	walk(parser.Group, "")
+	return buf.String()
+}
+
+func iniName(opt *flags.Option) string {
+	if name := opt.Field().Tag.Get("ini-name"); name != "" {
+		return name
+	}
+	return opt.Field().Name
+}
+
+// envName converts the camel case name to upper snake case, e.g.
+// maxConnections to MAX_CONNECTIONS.
+func envName(name string) string {
+	var b strings.Builder
+	rs := []rune(name)
+	for i, r := range rs {
+		switch {
+		case r == '-' || r == '.' || unicode.IsSpace(r):
+			b.WriteByte('_')
+			continue
+		case i > 0 && unicode.IsUpper(r) && (unicode.IsLower(rs[i-1]) || unicode.IsDigit(rs[i-1])):
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
@@ -0,0 +1,90 @@
+package mo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/skyasker/go-flags"
+)
+
+func TestEnvName(t *testing.T) {
+	for name, want := range map[string]string{
+		"port":             "PORT",
+		"Port":             "PORT",
+		"maxConnections":   "MAX_CONNECTIONS",
+		"numServerWorkers": "NUM_SERVER_WORKERS",
+		"sql-ip":           "SQL_IP",
+		"IP":               "IP",
+	} {
+		if got := envName(name); got != want {
+			t.Errorf("envName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	yml := filepath.Join(dir, "mo.yaml")
+	data := "log:\n  level: 2\ntcp:\n  port: 7000\n  maxConnections: 7\ngrpc:\n  port: 9100\ncron:\n  specs:\n    pkg.Svc/Method: \"*/5 * * * * *\"\n"
+	if err := os.WriteFile(yml, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MO_GRPC_PORT", "9200")
+	t.Setenv("MO_TCP_MAX_CONNECTIONS", "8")
+
+	o := &Options{}
+	err := load(flags.NewParser(o, flags.IgnoreUnknown), o, []string{"-c", yml, "--tcp-max-connections", "9"})
+	if err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	if o.Log.Level != 2 || o.TCP.Port != 7000 {
+		t.Errorf("file not applied, log level %d, tcp port %d", o.Log.Level, o.TCP.Port)
+	}
+	if o.GRPC.Port != 9200 {
+		t.Errorf("grpc port = %d, want the env one 9200", o.GRPC.Port)
+	}
+	if o.TCP.MaxConnections != 9 {
+		t.Errorf("tcp max connections = %d, want the flag one 9", o.TCP.MaxConnections)
+	}
+	if o.CRON.Specs["pkg.Svc/Method"] != "*/5 * * * * *" {
+		t.Errorf("cron specs = %v", o.CRON.Specs)
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	o := &Options{}
+	if err := load(flags.NewParser(o, flags.IgnoreUnknown), o, []string{"-c", filepath.Join(t.TempDir(), "none.json")}); err == nil {
+		t.Error("load() of an explicit missing file error = nil")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	o := &Options{}
+	o.HTTP.Port = 8000
+	o.GRPC.Port = 8000
+	o.NATS.Credentials = "creds"
+	if err := o.Validate(); err == nil {
+		t.Error("Validate() error = nil, want port collision and missing nats urls")
+	}
+	o.GRPC.Port = 9000
+	o.NATS.URLs = "nats://127.0.0.1:4222"
+	if err := o.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+	o.WS.Port = 8000
+	o.WS.Path = "/ws" // mounted on http, the port is not used
+	if err := o.Validate(); err != nil {
+		t.Errorf("Validate() of mounted ws error = %v", err)
+	}
+	o.WS.Path = "ws"
+	if err := o.Validate(); err == nil {
+		t.Error("Validate() error = nil, want invalid ws path")
+	}
+	o.WS.Path = ""
+	o.WS.Port = 0
+	o.NATS.URLs = "nats://127.0.0.1:4222,nats://"
+	if err := o.Validate(); err == nil {
+		t.Error("Validate() error = nil, want invalid nats url")
+	}
+}
@@ -107,6 +107,7 @@ func Tags(tags ...Tag) Option {
 
 type Log struct {
 	opt    Options
+	level  zap.AtomicLevel
 	logger *zap.Logger
 	suger  *zap.SugaredLogger
 }
@@ -121,6 +122,7 @@ func New(opts ...Option) (*Log, func()) {
 	for _, o := range opts {
 		o(&log.opt)
 	}
+	log.level = zap.NewAtomicLevelAt(zapLevel(log.opt.Level))
 
 	var cores []zapcore.Core
 
@@ -144,7 +146,7 @@ func New(opts ...Option) (*Log, func()) {
 			MaxBackups: 3,
 			MaxAge:     7, // days
 		}),
-		zapLevel(log.opt.Level),
+		log.level,
 	))
 
 	if log.opt.Console {
@@ -158,11 +160,12 @@ func New(opts ...Option) (*Log, func()) {
 		// high-priority logs.
 
 		// First, define our level-handling logic.
+		level := log.level
 		highPriority := zap.LevelEnablerFunc(func(lvl zapcore.Level) bool {
-			return lvl >= zapcore.ErrorLevel
+			return lvl >= zapcore.ErrorLevel && level.Enabled(lvl)
 		})
 		lowPriority := zap.LevelEnablerFunc(func(lvl zapcore.Level) bool {
-			return lvl < zapcore.ErrorLevel
+			return lvl < zapcore.ErrorLevel && level.Enabled(lvl)
 		})
 
 		// Assume that we have clients for two Kafka topics. The clients implement
@@ -243,7 +246,27 @@ func Close() {
 	}
 }
 
-func zapLevel(lvl Level) zapcore.LevelEnabler {
+// SetLevel changes the level of the logger at runtime.
+func SetLevel(lvl Level) {
+	log.level.SetLevel(zapLevel(lvl))
+}
+
+// GetLevel returns the level of the logger.
+func GetLevel() Level {
+	switch log.level.Level() {
+	case zap.DebugLevel:
+		return LevelDebug
+	case zap.WarnLevel:
+		return LevelWarn
+	case zap.ErrorLevel:
+		return LevelError
+	case zap.FatalLevel:
+		return LevelFatal
+	}
+	return LevelInfo
+}
+
+func zapLevel(lvl Level) zapcore.Level {
 	switch lvl {
 	case LevelDebug:
 		return zap.DebugLevel
@@ -668,5 +691,6 @@ func init() {
 		logger: origin,
 		suger:  origin.Sugar(),
 		opt:    defaultOptions(),
+		level:  zap.NewAtomicLevelAt(zap.DebugLevel),
 	}
 }
@@ -2,16 +2,47 @@ package uhttp
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
+// CorsConfig .
+type CorsConfig struct {
+	// Origins is the comma separated allowed origins, "*" allows any origin.
+	Origins string
+	Headers string
+	Methods string
+}
+
+// DefaultCorsConfig allows any origin.
+var DefaultCorsConfig = CorsConfig{
+	Origins: "*",
+	Headers: "Content-Type,AccessToken,X-CSRF-Token,x-xsrf-token,Authorization,Token",
+	Methods: "POST, GET, PUT, DELETE, OPTIONS, PRI",
+}
+
+// Cors .
 func Cors() gin.HandlerFunc {
+	return CorsFunc(func() CorsConfig {
+		return DefaultCorsConfig
+	})
+}
+
+// CorsFunc is like Cors but gets the config of every request from cfg, so
+// the config can be changed at runtime.
+func CorsFunc(cfg func() CorsConfig) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		cc := cfg()
 		method := c.Request.Method
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Headers", "Content-Type,AccessToken,X-CSRF-Token,x-xsrf-token,Authorization,Token")
-		c.Header("Access-Control-Allow-Methods", "POST, GET, PUT, DELETE, OPTIONS, PRI")
+		if origin := allowOrigin(cc.Origins, c.GetHeader("Origin")); origin != "" {
+			c.Header("Access-Control-Allow-Origin", origin)
+			if origin != "*" {
+				c.Header("Vary", "Origin")
+			}
+		}
+		c.Header("Access-Control-Allow-Headers", cc.Headers)
+		c.Header("Access-Control-Allow-Methods", cc.Methods)
 		c.Header("Access-Control-Expose-Headers", "Content-Length, Access-Control-Allow-Origin, Access-Control-Allow-Headers, Content-Type")
 		c.Header("Access-Control-Allow-Credentials", "true")
 
@@ -24,3 +55,16 @@ func Cors() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+func allowOrigin(origins, origin string) string {
+	for _, o := range strings.Split(origins, ",") {
+		o = strings.TrimSpace(o)
+		if o == "*" {
+			return "*"
+		}
+		if o != "" && o == origin {
+			return origin
+		}
+	}
+	return ""
+}
@@ -1,14 +1,20 @@
 package mo
 
 import (
+	"context"
+	"errors"
 	"os"
 	"os/signal"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/xsuners/mo/log"
 	"github.com/xsuners/mo/naming"
 	"github.com/xsuners/mo/net/description"
+	"github.com/xsuners/mo/sync/errgroup"
+	"github.com/xsuners/mo/sync/event"
+	"go.uber.org/zap"
 )
 
 type ssd struct {
@@ -17,13 +23,48 @@ type ssd struct {
 }
 
 type server struct {
+	name   string
+	kind   string
 	server description.Server
 	cf     func()
 	ssds   []*ssd
+	build  func() (description.Server, func(), error)
+}
+
+// state is the lifecycle state of an app.
+type state int32
+
+const (
+	stateStarting state = iota
+	stateServing
+	stateDraining
+	stateStopped
+)
+
+func (s state) String() string {
+	switch s {
+	case stateStarting:
+		return "starting"
+	case stateServing:
+		return "serving"
+	case stateDraining:
+		return "draining"
+	case stateStopped:
+		return "stopped"
+	}
+	return "unknown"
 }
 
 type App interface {
+	// Serve runs the app until a quit signal is received.
 	Serve() error
+	// Run runs the app until ctx is done or any server fails.
+	Run(ctx context.Context) error
+	// Ready returns a channel that is closed when the app is serving.
+	Ready() <-chan struct{}
+	// Server returns the server of the kind, e.g. "xtcp", and name, it
+	// returns nil if there is no such server.
+	Server(kind, name string) description.Server
 }
 
 // app
@@ -32,6 +73,15 @@ type app struct {
 
 	log    *log.Log
 	naming naming.Naming
+	admin  *admin
+	state  int32
+	ready  *event.Event
+	ints   []description.UnaryServerInterceptor
+
+	reloaders []ReloadFunc
+
+	readyTimeout time.Duration
+	drainTimeout time.Duration
 
 	cf   func()
 	logc func()
@@ -39,60 +89,191 @@ type app struct {
 
 func New(cf func(), opt ...Option) App {
 	a := &app{
-		cf: cf,
+		cf:           cf,
+		ready:        event.NewEvent(),
+		readyTimeout: 10 * time.Second,
+		drainTimeout: 10 * time.Second,
 	}
 	for _, o := range opt {
 		o(a)
 	}
+	for _, s := range a.servers {
+		var err error
+		if s.server, s.cf, err = s.build(); err != nil {
+			panic(err)
+		}
+	}
+	a.mount()
 	return a
 }
 
 // Serve .
 func (a *app) Serve() (err error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(c)
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case s := <-c:
+				log.Infof("get a signal %s", s.String())
+				switch s {
+				case syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT:
+					cancel()
+					return
+				case syscall.SIGHUP:
+					a.reload()
+				default:
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	return a.Run(ctx)
+}
+
+// Run starts all servers, registers them to naming once they are ready and
+// blocks until ctx is done or any server fails. The app is shut down before
+// Run returns.
+func (a *app) Run(ctx context.Context) (err error) {
+	a.setState(stateStarting)
+	if a.admin != nil {
+		if err = a.admin.start(); err != nil {
+			return
+		}
+	}
+
+	errc := make(chan error, len(a.servers))
 	for _, s := range a.servers {
 		for _, sd := range s.ssds {
 			s.server.Register(sd.svc, sd.sds...)
 		}
 		go func(s description.Server) {
-			if err := s.Serve(); err != nil {
-				panic(err)
-			}
+			errc <- s.Serve()
 		}(s.server)
-		if a.naming == nil {
-			log.Infos("naming is nil")
-			return
-		}
-		time.Sleep(time.Second) // TODO 更优雅点
-		if err := s.server.Naming(a.naming); err != nil {
-			panic(err)
-		}
 	}
+	defer a.shutdown()
 
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT)
+	if err = a.wait(ctx, errc); err != nil {
+		return
+	}
 
-	for {
-		s := <-c
-		log.Infof("get a signal %s", s.String())
-		switch s {
-		case syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT:
-			if a.naming != nil {
-				a.naming.Deregister()
+	if a.naming == nil {
+		log.Infos("naming is nil")
+	} else {
+		for _, s := range a.servers {
+			if err = s.server.Naming(a.naming); err != nil {
+				return
 			}
-			for _, s := range a.servers {
-				s.cf()
-			}
-			if a.cf != nil {
-				a.cf()
+		}
+	}
+	a.setState(stateServing)
+	a.ready.Fire()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err = <-errc:
+			if err != nil {
+				log.Errors("mo: server stopped", zap.Error(err))
+				return
 			}
-			if a.logc != nil {
-				a.logc()
+		}
+	}
+}
+
+// Ready .
+func (a *app) Ready() <-chan struct{} {
+	return a.ready.Done()
+}
+
+// Server .
+func (a *app) Server(kind, name string) description.Server {
+	for _, s := range a.servers {
+		if s.kind == kind && s.name == name {
+			return s.server
+		}
+	}
+	return nil
+}
+
+// wait waits for all the servers to be ready.
+func (a *app) wait(ctx context.Context, errc <-chan error) error {
+	timeout := time.NewTimer(a.readyTimeout)
+	defer timeout.Stop()
+	for _, s := range a.servers {
+		r, ok := s.server.(description.Readier)
+		if !ok {
+			continue
+		}
+	wait:
+		for {
+			select {
+			case <-r.Ready():
+				break wait
+			case err := <-errc:
+				if err != nil {
+					return err
+				}
+			case <-timeout.C:
+				return errors.New("mo: wait servers ready timeout")
+			case <-ctx.Done():
+				return ctx.Err()
 			}
-			time.Sleep(time.Second)
-			return
-		case syscall.SIGHUP:
-		default:
-			return
 		}
 	}
+	return nil
+}
+
+// shutdown drains the servers while deregistering the app from naming, so
+// the long connection clients told to go away find the other instances. It
+// then stops all servers and calls the cleanup functions.
+func (a *app) shutdown() {
+	a.setState(stateDraining)
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.drainTimeout)
+	defer cancel()
+	g := errgroup.WithContext(ctx)
+	for _, s := range a.servers {
+		if d, ok := s.server.(description.Drainer); ok {
+			g.Go(d.Drain)
+		}
+	}
+	if a.naming != nil {
+		a.naming.Deregister()
+	}
+	if err := g.Wait(); err != nil {
+		log.Warns("mo: drain servers error", zap.Error(err))
+	}
+
+	for _, s := range a.servers {
+		s.cf()
+	}
+	a.setState(stateStopped)
+	if a.admin != nil {
+		a.admin.stop()
+	}
+	if a.cf != nil {
+		a.cf()
+	}
+	if a.logc != nil {
+		a.logc()
+	}
+}
+
+func (a *app) setState(s state) {
+	atomic.StoreInt32(&a.state, int32(s))
+}
+
+func (a *app) getState() state {
+	return state(atomic.LoadInt32(&a.state))
 }
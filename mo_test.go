@@ -0,0 +1,145 @@
+package mo
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xsuners/mo/naming"
+	"github.com/xsuners/mo/naming/memory"
+	"github.com/xsuners/mo/net/description"
+)
+
+// events records the lifecycle events of an app in order.
+type events struct {
+	mu   sync.Mutex
+	list []string
+}
+
+func (e *events) add(format string, args ...interface{}) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.list = append(e.list, fmt.Sprintf(format, args...))
+}
+
+// index returns the position of the event, -1 if it is not recorded.
+func (e *events) index(event string) int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i, v := range e.list {
+		if v == event {
+			return i
+		}
+	}
+	return -1
+}
+
+// slowServer is ready, drained and deregistered a while after asked, so that
+// the app has to wait for them.
+type slowServer struct {
+	name  string
+	ev    *events
+	ready chan struct{}
+	stop  chan struct{}
+}
+
+func (s *slowServer) Register(ss interface{}, sds ...*description.ServiceDesc) {}
+
+func (s *slowServer) Serve() error {
+	time.Sleep(20 * time.Millisecond)
+	s.ev.add("ready %s", s.name)
+	close(s.ready)
+	<-s.stop
+	return nil
+}
+
+func (s *slowServer) Ready() <-chan struct{} {
+	return s.ready
+}
+
+func (s *slowServer) Naming(n naming.Naming) error {
+	return n.Register(&naming.Service{Name: s.name})
+}
+
+func (s *slowServer) Drain(ctx context.Context) error {
+	time.Sleep(20 * time.Millisecond)
+	s.ev.add("drained %s", s.name)
+	return nil
+}
+
+type slowNaming struct {
+	*memory.Naming
+	ev *events
+}
+
+func (n *slowNaming) Register(svc *naming.Service) error {
+	n.ev.add("registered %s", svc.Name)
+	return n.Naming.Register(svc)
+}
+
+func (n *slowNaming) Deregister() {
+	time.Sleep(20 * time.Millisecond)
+	n.Naming.Deregister()
+	n.ev.add("deregistered")
+}
+
+func TestLifecycle(t *testing.T) {
+	ev := &events{}
+	var adm *admin
+	// adminUp reports whether the admin endpoints are still served.
+	adminUp := func() bool {
+		c, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", adm.port))
+		if err != nil {
+			return false
+		}
+		c.Close()
+		return true
+	}
+	a := New(func() {
+		if !adminUp() {
+			ev.add("admin stopped")
+		}
+	}, Admin(0), Naming(&slowNaming{Naming: memory.New(), ev: ev})).(*app)
+	adm = a.admin
+	names := []string{"a", "b"}
+	for _, name := range names {
+		s := &slowServer{name: name, ev: ev, ready: make(chan struct{}), stop: make(chan struct{})}
+		a.servers = append(a.servers, &server{name: name, kind: "slow", server: s, cf: func() {
+			if adminUp() {
+				ev.add("closed %s", s.name)
+			}
+			close(s.stop)
+		}})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- a.Run(ctx) }()
+	select {
+	case <-a.Ready():
+	case <-time.After(time.Second):
+		t.Fatal("app is not ready")
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	before := func(first, then string) {
+		t.Helper()
+		if i, j := ev.index(first), ev.index(then); i < 0 || j < 0 || i > j {
+			t.Errorf("%q is not before %q, events %v", first, then, ev.list)
+		}
+	}
+	for _, s := range names {
+		for _, r := range names {
+			before("ready "+s, "registered "+r)
+			before("drained "+s, "closed "+r)
+		}
+		before("deregistered", "closed "+s)
+		before("closed "+s, "admin stopped")
+	}
+}
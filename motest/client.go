@@ -0,0 +1,235 @@
+package motest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/xsuners/mo/net/description"
+	"github.com/xsuners/mo/net/encoding"
+	"github.com/xsuners/mo/net/encoding/proto"
+	"github.com/xsuners/mo/net/message"
+	"github.com/xsuners/mo/net/xgrpc/client"
+	"github.com/xsuners/mo/net/xgrpc/client/balancer/rr"
+	hc "github.com/xsuners/mo/net/xhttp/client"
+	"github.com/xsuners/mo/net/xlocal"
+	"github.com/xsuners/mo/net/xtcp"
+	"github.com/xsuners/mo/net/xws"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// defaultTimeout bounds the calls of Conn without deadline.
+const defaultTimeout = 10 * time.Second
+
+// Conn calls the services of a xws server with the proto codec, one call at
+// a time. The messages other than the replies, e.g. pushes, are
+// dropped.
+type Conn struct {
+	mu    sync.Mutex
+	raw   net.Conn
+	codec encoding.Codec
+	read  func() ([]byte, error)
+	write func([]byte) error
+	seq   int64
+}
+
+var _ description.ClientConnInterface = (*Conn)(nil)
+
+// TCPClient dials the unnamed xtcp server, the conn is closed when the test
+// is done.
+func (a *App) TCPClient(opt ...xtcp.DialOption) *xtcp.ClientConn {
+	a.t.Helper()
+	return a.NamedTCPClient("", opt...)
+}
+
+// NamedTCPClient dials the xtcp server with the given name.
+func (a *App) NamedTCPClient(name string, opt ...xtcp.DialOption) *xtcp.ClientConn {
+	a.t.Helper()
+	cc, err := xtcp.Dial(a.Addr("xtcp", name), opt...)
+	if err != nil {
+		a.t.Fatalf("motest: dial xtcp error: %v", err)
+	}
+	a.t.Cleanup(cc.Close)
+	return cc
+}
+
+// WSClient dials the unnamed xws server, the conn is closed when the test is
+// done.
+func (a *App) WSClient() *Conn {
+	a.t.Helper()
+	return a.NamedWSClient("")
+}
+
+// NamedWSClient dials the xws server with the given name.
+func (a *App) NamedWSClient(name string) *Conn {
+	a.t.Helper()
+	addr := a.Addr("xws", name)
+	if s, ok := a.Server("xws", name).(*xws.Server); ok {
+		addr += s.Path() // mounted on xhttp
+	}
+	cc, err := DialWS(addr)
+	if err != nil {
+		a.t.Fatalf("motest: dial xws error: %v", err)
+	}
+	a.t.Cleanup(cc.Close)
+	return cc
+}
+
+// GRPCClient dials the unnamed xgrpc server.
+func (a *App) GRPCClient(opt ...client.DialOption) description.ClientConnInterface {
+	a.t.Helper()
+	return a.NamedGRPCClient("", opt...)
+}
+
+// NamedGRPCClient dials the xgrpc server with the given name.
+func (a *App) NamedGRPCClient(name string, opt ...client.DialOption) description.ClientConnInterface {
+	a.t.Helper()
+	opts := []client.DialOption{
+		client.IP("127.0.0.1"),
+		client.Port(a.Port("xgrpc", name)),
+		client.Balancer(rr.Name),
+	}
+	cc, err := client.New(append(opts, opt...)...)
+	if err != nil {
+		a.t.Fatalf("motest: dial xgrpc error: %v", err)
+	}
+	a.t.Cleanup(cc.(*client.Client).Close)
+	return cc
+}
+
+// HTTPClient returns a client of the unnamed xhttp server.
+func (a *App) HTTPClient(opt ...hc.Option) *hc.Client {
+	a.t.Helper()
+	return a.NamedHTTPClient("", opt...)
+}
+
+// NamedHTTPClient returns a client of the xhttp server with the given name.
+func (a *App) NamedHTTPClient(name string, opt ...hc.Option) *hc.Client {
+	a.t.Helper()
+	opts := []hc.Option{
+		hc.IP("127.0.0.1"),
+		hc.Port(a.Port("xhttp", name)),
+	}
+	cc, err := hc.New(append(opts, opt...)...)
+	if err != nil {
+		a.t.Fatalf("motest: new xhttp client error: %v", err)
+	}
+	a.t.Cleanup(cc.Close)
+	return cc
+}
+
+// LocalClient returns a client conn of the unnamed xlocal server.
+func (a *App) LocalClient(opt ...xlocal.DialOption) *xlocal.ClientConn {
+	a.t.Helper()
+	cc, err := xlocal.Dial(a.Server("xlocal", ""), opt...)
+	if err != nil {
+		a.t.Fatalf("motest: dial xlocal error: %v", err)
+	}
+	return cc
+}
+
+// DialWS dials the xws server at addr and selects the proto codec.
+func DialWS(addr string) (*Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+	d := ws.Dialer{Protocols: []string{"protobuf"}}
+	raw, br, _, err := d.Dial(ctx, "ws://"+addr)
+	if err != nil {
+		return nil, err
+	}
+	var r io.Reader = raw
+	if br != nil { // data sent by the server right after the handshake
+		r = br
+	}
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{r, raw}
+	return &Conn{
+		raw:   raw,
+		codec: encoding.GetCodec(proto.Name),
+		read: func() ([]byte, error) {
+			data, _, err := wsutil.ReadServerData(rw)
+			return data, err
+		},
+		write: func(data []byte) error {
+			return wsutil.WriteClientBinary(raw, data)
+		},
+	}, nil
+}
+
+// Invoke sends the request to method, which is in form of /service/method,
+// and waits for the reply. The outgoing metadata of ctx is sent as well.
+func (cc *Conn) Invoke(ctx context.Context, method string, args interface{}, reply interface{}, opts ...description.CallOption) error {
+	method = strings.TrimPrefix(method, "/")
+	pos := strings.LastIndex(method, "/")
+	if pos == -1 {
+		return fmt.Errorf("motest: invalid method (%s)", method)
+	}
+	data, err := cc.codec.Marshal(args)
+	if err != nil {
+		return err
+	}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.seq++
+	req := &message.Message{
+		Service:   method[:pos],
+		Method:    method[pos+1:],
+		Data:      data,
+		Messageid: strconv.FormatInt(cc.seq, 10),
+	}
+	if md, ok := metadata.FromOutgoingContext(ctx); ok {
+		req.Metas = message.EncodeMetadata(md)
+	}
+	if data, err = cc.codec.Marshal(req); err != nil {
+		return err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultTimeout)
+	}
+	cc.raw.SetDeadline(deadline)
+	defer cc.raw.SetDeadline(time.Time{})
+	if err = cc.write(data); err != nil {
+		return err
+	}
+	for {
+		data, err := cc.read()
+		if err != nil {
+			return err
+		}
+		res := new(message.Message)
+		if err = cc.codec.Unmarshal(data, res); err != nil {
+			return err
+		}
+		if res.Messageid != req.Messageid {
+			continue
+		}
+		if res.Code != 0 {
+			return status.Error(codes.Code(res.Code), res.Desc)
+		}
+		return cc.codec.Unmarshal(res.Data, reply)
+	}
+}
+
+// NewStream is not supported.
+func (cc *Conn) NewStream(ctx context.Context, desc *description.StreamDesc, method string, opts ...description.CallOption) (description.ClientStream, error) {
+	return nil, errors.New("motest: stream is not supported")
+}
+
+// Close .
+func (cc *Conn) Close() {
+	cc.raw.Close()
+}
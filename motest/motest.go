@@ -0,0 +1,146 @@
+// Package motest boots an mo.App for tests. The servers added by the options
+// of this package listen on ports picked by the system, and the app is shut
+// down when the test is done.
+//
+//	app := motest.New(t, motest.TCP(), mo.TCPSDS(svc, &pb.Service_ServiceDesc))
+//	cc := app.TCPClient()
+//	err := cc.Invoke(ctx, "/pkg.Service/Method", in, out)
+package motest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/xsuners/mo"
+	"github.com/xsuners/mo/naming/memory"
+	"github.com/xsuners/mo/net/description"
+	"github.com/xsuners/mo/net/xgrpc"
+	"github.com/xsuners/mo/net/xhttp"
+	"github.com/xsuners/mo/net/xtcp"
+	"github.com/xsuners/mo/net/xws"
+)
+
+// App is an app running in a test.
+type App struct {
+	t      testing.TB
+	app    mo.App
+	naming *memory.Naming
+}
+
+// New builds an app of the options and runs it until the test is done, it
+// returns once all the servers are ready. The app is registered to an
+// in-memory naming unless opt has a naming.
+func New(t testing.TB, opt ...mo.Option) *App {
+	t.Helper()
+	a := &App{
+		t:      t,
+		naming: memory.New(),
+	}
+	a.app = mo.New(nil, append([]mo.Option{mo.Naming(a.naming)}, opt...)...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	var err error
+	go func() {
+		err = a.app.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		if err != nil {
+			t.Errorf("motest: app stopped with error: %v", err)
+		}
+	})
+
+	select {
+	case <-a.app.Ready():
+	case <-done:
+		e := err
+		err = nil // reported already
+		t.Fatalf("motest: app stopped before ready: %v", e)
+	}
+	return a
+}
+
+// App returns the underlying app.
+func (a *App) App() mo.App {
+	return a.app
+}
+
+// Naming returns the in-memory naming the app is registered to.
+func (a *App) Naming() *memory.Naming {
+	return a.naming
+}
+
+// Server returns the server of the kind and name, it fails the test if
+// there is no such server.
+func (a *App) Server(kind, name string) description.Server {
+	a.t.Helper()
+	s := a.app.Server(kind, name)
+	if s == nil {
+		a.t.Fatalf("motest: no %s server named %q", kind, name)
+	}
+	return s
+}
+
+// Port returns the listening port of the server of the kind and name.
+func (a *App) Port(kind, name string) int {
+	a.t.Helper()
+	p, ok := a.Server(kind, name).(description.Porter)
+	if !ok {
+		a.t.Fatalf("motest: %s server does not listen on a port", kind)
+	}
+	return p.Port()
+}
+
+// Addr returns the loopback address of the server of the kind and name.
+func (a *App) Addr(kind, name string) string {
+	a.t.Helper()
+	return fmt.Sprintf("127.0.0.1:%d", a.Port(kind, name))
+}
+
+// TCP adds a xtcp server listening on an ephemeral port.
+func TCP(opts ...xtcp.Option) mo.Option {
+	return NamedTCP("", opts...)
+}
+
+// NamedTCP adds a xtcp server with the given name listening on an ephemeral
+// port.
+func NamedTCP(name string, opts ...xtcp.Option) mo.Option {
+	return mo.NamedTCP(name, append(opts[:len(opts):len(opts)], xtcp.Port(0))...)
+}
+
+// WS adds a xws server listening on an ephemeral port.
+func WS(opts ...xws.Option) mo.Option {
+	return NamedWS("", opts...)
+}
+
+// NamedWS adds a xws server with the given name listening on an ephemeral
+// port.
+func NamedWS(name string, opts ...xws.Option) mo.Option {
+	return mo.NamedWS(name, append(opts[:len(opts):len(opts)], xws.Port(0))...)
+}
+
+// HTTP adds a xhttp server listening on an ephemeral port.
+func HTTP(opts ...xhttp.Option) mo.Option {
+	return NamedHTTP("", opts...)
+}
+
+// NamedHTTP adds a xhttp server with the given name listening on an
+// ephemeral port.
+func NamedHTTP(name string, opts ...xhttp.Option) mo.Option {
+	return mo.NamedHTTP(name, append(opts[:len(opts):len(opts)], xhttp.Port(0))...)
+}
+
+// GRPC adds a xgrpc server listening on an ephemeral port.
+func GRPC(opts ...xgrpc.Option) mo.Option {
+	return NamedGRPC("", opts...)
+}
+
+// NamedGRPC adds a xgrpc server with the given name listening on an
+// ephemeral port.
+func NamedGRPC(name string, opts ...xgrpc.Option) mo.Option {
+	return mo.NamedGRPC(name, append(opts[:len(opts):len(opts)], xgrpc.Port(0))...)
+}
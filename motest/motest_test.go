@@ -0,0 +1,192 @@
+package motest
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xsuners/mo"
+	"github.com/xsuners/mo/net/connection"
+	"github.com/xsuners/mo/net/description"
+	"github.com/xsuners/mo/net/encoding/proto"
+	"github.com/xsuners/mo/net/message"
+	"github.com/xsuners/mo/net/xtcp"
+	"github.com/xsuners/mo/net/xws"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type echoServer interface {
+	Echo(ctx context.Context, in *wrapperspb.StringValue) (*wrapperspb.StringValue, error)
+}
+
+type echo struct{}
+
+func (echo) Echo(ctx context.Context, in *wrapperspb.StringValue) (*wrapperspb.StringValue, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	out := &wrapperspb.StringValue{Value: in.Value}
+	if v := md.Get("suffix"); len(v) > 0 {
+		out.Value += v[0]
+	}
+	if v := md.Get("push"); len(v) > 0 {
+		if conn, ok := connection.FromContext(ctx); ok {
+			conn.WriteMessage(&message.Message{Service: "push", Desc: v[0]})
+		}
+	}
+	return out, nil
+}
+
+func echoHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor description.UnaryServerInterceptor) (interface{}, error) {
+	in := new(wrapperspb.StringValue)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(echoServer).Echo(ctx, in)
+	}
+	info := &description.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/test.Echo/Echo",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(echoServer).Echo(ctx, req.(*wrapperspb.StringValue))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var echoDesc = description.ServiceDesc{
+	ServiceName: "test.Echo",
+	HandlerType: (*echoServer)(nil),
+	Methods: []description.MethodDesc{
+		{
+			MethodName: "Echo",
+			Handler:    echoHandler,
+		},
+	},
+}
+
+func TestApp(t *testing.T) {
+	app := New(t,
+		TCP(), mo.TCPSDS(echo{}, &echoDesc),
+		WS(), mo.WSSDS(echo{}, &echoDesc),
+		GRPC(), mo.GRPCSDS(echo{}, &echoDesc),
+		mo.LOCAL(), mo.LOCALSDS(echo{}, &echoDesc),
+	)
+	if app.Port("xtcp", "") == 0 || app.Port("xws", "") == 0 || app.Port("xgrpc", "") == 0 {
+		t.Fatal("ports are not read back")
+	}
+	if len(app.Naming().Lookup("tcp.test")) != 1 {
+		t.Errorf("naming services = %v", app.Naming().Services())
+	}
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "suffix", "!")
+	for name, cc := range map[string]description.ClientConnInterface{
+		"xtcp":   app.TCPClient(),
+		"xws":    app.WSClient(),
+		"xgrpc":  app.GRPCClient(),
+		"xlocal": app.LocalClient(),
+	} {
+		out := new(wrapperspb.StringValue)
+		if err := cc.Invoke(ctx, "/test.Echo/Echo", &wrapperspb.StringValue{Value: name}, out); err != nil {
+			t.Errorf("%s: Invoke() error = %v", name, err)
+			continue
+		}
+		if out.Value != name+"!" {
+			t.Errorf("%s: out = %q, want %q", name, out.Value, name+"!")
+		}
+	}
+}
+
+func TestMountedWS(t *testing.T) {
+	app := New(t,
+		HTTP(),
+		WS(xws.Path("/ws")), mo.WSSDS(echo{}, &echoDesc),
+	)
+	port := app.Port("xhttp", "")
+	if got := app.Port("xws", ""); got != port {
+		t.Fatalf("xws port = %d, want xhttp port %d", got, port)
+	}
+	if svcs := app.Naming().Lookup("ws.test"); len(svcs) != 1 || svcs[0].Port != port {
+		t.Errorf("naming services = %v", app.Naming().Services())
+	}
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "suffix", "!")
+	out := new(wrapperspb.StringValue)
+	if err := app.WSClient().Invoke(ctx, "/test.Echo/Echo", &wrapperspb.StringValue{Value: "ws"}, out); err != nil {
+		t.Fatalf("Invoke() error = %v", err)
+	}
+	if out.Value != "ws!" {
+		t.Errorf("out = %q, want ws!", out.Value)
+	}
+}
+
+func TestTCPClient(t *testing.T) {
+	pushes := make(chan *message.Message, 1)
+	app := New(t, TCP(), mo.TCPSDS(echo{}, &echoDesc))
+	cc := app.TCPClient(xtcp.MessageOption(func(data []byte) error {
+		msg := new(message.Message)
+		if err := (proto.Codec{}).Unmarshal(data, msg); err != nil {
+			return err
+		}
+		pushes <- msg
+		return nil
+	}))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "push", "hi")
+	out := new(wrapperspb.StringValue)
+	if err := cc.Invoke(ctx, "/test.Echo/Echo", &wrapperspb.StringValue{Value: "a"}, out); err != nil {
+		t.Fatalf("Invoke() error = %v", err)
+	}
+	select {
+	case msg := <-pushes:
+		if msg.Desc != "hi" {
+			t.Errorf("push = %v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Error("push is not received")
+	}
+
+	err := cc.Invoke(context.Background(), "/test.Echo/Unknown", &wrapperspb.StringValue{}, out)
+	if status.Code(err) != codes.Unimplemented {
+		t.Errorf("Invoke() unknown method error = %v", err)
+	}
+
+	cc.Close()
+	err = cc.Invoke(context.Background(), "/test.Echo/Echo", &wrapperspb.StringValue{}, out)
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("Invoke() on closed conn error = %v", err)
+	}
+}
+
+func TestTCPCompression(t *testing.T) {
+	app := New(t, TCP(xtcp.Compressors("snappy")), mo.TCPSDS(echo{}, &echoDesc))
+	cc := app.TCPClient(xtcp.WithCompressors("gzip", "snappy"), xtcp.WithCompressThreshold(64))
+	if c := cc.Compressor(); c == nil || c.Name() != "snappy" {
+		t.Fatalf("compressor = %v, want snappy", c)
+	}
+	in := strings.Repeat("mo ", 1000)
+	out := new(wrapperspb.StringValue)
+	if err := cc.Invoke(context.Background(), "/test.Echo/Echo", &wrapperspb.StringValue{Value: in}, out); err != nil {
+		t.Fatalf("Invoke() error = %v", err)
+	}
+	if out.Value != in {
+		t.Errorf("out = %d bytes, want %d", len(out.Value), len(in))
+	}
+}
+
+func TestClientsClosed(t *testing.T) {
+	var cc description.ClientConnInterface
+	t.Run("app", func(t *testing.T) {
+		app := New(t, GRPC(), mo.GRPCSDS(echo{}, &echoDesc))
+		cc = app.GRPCClient()
+	})
+	// Canceled rather than Unavailable since the conn is closed, not only
+	// the server
+	err := cc.Invoke(context.Background(), "/test.Echo/Echo", &wrapperspb.StringValue{}, new(wrapperspb.StringValue))
+	if status.Code(err) != codes.Canceled {
+		t.Errorf("Invoke() after cleanup error = %v, want Canceled", err)
+	}
+}
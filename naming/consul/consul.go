@@ -43,7 +43,10 @@ type Naming struct {
 	services []*naming.Service
 }
 
-var _ naming.Naming = (*Naming)(nil)
+var (
+	_ naming.Naming   = (*Naming)(nil)
+	_ naming.Resolver = (*Naming)(nil)
+)
 
 // TODO
 func New(opt ...Option) (nm naming.Naming) {
@@ -116,3 +119,25 @@ func (n *Naming) Register(svc *naming.Service) (err error) {
 func serviceID(svc *naming.Service) string {
 	return fmt.Sprintf("%v-%v-%v", svc.Name, svc.IP, svc.Port)
 }
+
+// Resolve returns the passing instances of the service name.
+func (n *Naming) Resolve(name string) (services []*naming.Service, err error) {
+	entries, _, err := n.client.Health().Service(name, "", true, nil)
+	if err != nil {
+		log.Errorw("resolve service error", "err", err, "service", name)
+		return
+	}
+	for _, entry := range entries {
+		addr := entry.Service.Address
+		if addr == "" {
+			addr = entry.Node.Address
+		}
+		services = append(services, &naming.Service{
+			Name: entry.Service.Service,
+			IP:   addr,
+			Port: entry.Service.Port,
+			Tag:  entry.Service.Tags,
+		})
+	}
+	return
+}
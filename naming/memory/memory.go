@@ -0,0 +1,59 @@
+package memory
+
+import (
+	"sync"
+
+	"github.com/xsuners/mo/naming"
+)
+
+// Naming keeps the registered services in memory, it is useful in tests.
+type Naming struct {
+	mu       sync.Mutex
+	services []*naming.Service
+}
+
+var (
+	_ naming.Naming   = (*Naming)(nil)
+	_ naming.Resolver = (*Naming)(nil)
+)
+
+func New() *Naming {
+	return &Naming{}
+}
+
+func (n *Naming) Deregister() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.services = nil
+}
+
+func (n *Naming) Register(svc *naming.Service) (err error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.services = append(n.services, svc)
+	return
+}
+
+// Services returns the registered services.
+func (n *Naming) Services() []*naming.Service {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]*naming.Service(nil), n.services...)
+}
+
+// Lookup returns the registered services of the name.
+func (n *Naming) Lookup(name string) (services []*naming.Service) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, svc := range n.services {
+		if svc.Name == name {
+			services = append(services, svc)
+		}
+	}
+	return
+}
+
+// Resolve implements naming.Resolver.
+func (n *Naming) Resolve(name string) ([]*naming.Service, error) {
+	return n.Lookup(name), nil
+}
@@ -26,3 +26,9 @@ type Naming interface {
 	Deregister()
 	Register(svc *Service) (err error)
 }
+
+// Resolver is implemented by the namings which can look up the instances of
+// a service, e.g. for the clients to dial through naming.
+type Resolver interface {
+	Resolve(name string) ([]*Service, error)
+}
@@ -2,6 +2,8 @@ package connection
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"net"
 
 	"github.com/xsuners/mo/net/encoding"
@@ -26,6 +28,11 @@ type User interface {
 	Disconnected()
 }
 
+// RemoteAddrKey is the key of the incoming metadata of the requests which
+// carries the remote address of the conn, the one of the client if the
+// server is behind a load balancer sending PROXY protocol headers.
+const RemoteAddrKey = "x-remote-addr"
+
 type connectionKey struct{}
 
 // FromContext .
@@ -38,3 +45,42 @@ func FromContext(ctx context.Context) (conn Conn, ok bool) {
 func NewContxet(ctx context.Context, conn Conn) context.Context {
 	return context.WithValue(ctx, connectionKey{}, conn)
 }
+
+// Peer is the identity of the verified certificate of the remote side.
+type Peer struct {
+	CommonName  string
+	DNSNames    []string
+	URIs        []string
+	Certificate *x509.Certificate
+}
+
+// NewPeer returns the peer of the verified client certificate of the conn,
+// nil if there is none.
+func NewPeer(state tls.ConnectionState) *Peer {
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	cert := state.VerifiedChains[0][0]
+	p := &Peer{
+		CommonName:  cert.Subject.CommonName,
+		DNSNames:    cert.DNSNames,
+		Certificate: cert,
+	}
+	for _, u := range cert.URIs {
+		p.URIs = append(p.URIs, u.String())
+	}
+	return p
+}
+
+type peerKey struct{}
+
+// PeerFromContext returns the peer of the conn serving the request.
+func PeerFromContext(ctx context.Context) (peer *Peer, ok bool) {
+	peer, ok = ctx.Value(peerKey{}).(*Peer)
+	return
+}
+
+// NewPeerContext returns a context carrying the peer.
+func NewPeerContext(ctx context.Context, peer *Peer) context.Context {
+	return context.WithValue(ctx, peerKey{}, peer)
+}
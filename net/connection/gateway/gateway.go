@@ -0,0 +1,428 @@
+// Package gateway pushes messages to the users connected to any node of a
+// cluster of xtcp and xws servers over nats.
+//
+//	h := hub.New()
+//	mo.TCP(xtcp.Hub(h))
+//	gw, err := gateway.New(natsConn, h)
+//	...
+//	gw.Push(ctx, "user-1", msg)
+//
+// Every node subscribes to its node subject, <prefix>.node.<node>, and to
+// the user subjects, <prefix>.user.<user>, of the users connected to it.
+// The nodes publish the users going online and offline to
+// <prefix>.presence, and a snapshot of their users every sync interval,
+// from which every node builds a presence index of users to nodes.
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/xsuners/mo/log"
+	"github.com/xsuners/mo/misc/ip"
+	"github.com/xsuners/mo/misc/unats"
+	"github.com/xsuners/mo/net/connection/hub"
+	"github.com/xsuners/mo/sync/event"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// ErrClosed is returned by Push after the gateway is closed.
+var ErrClosed = errors.New("gateway: closed")
+
+// Options of the gateway.
+type Options struct {
+	// Node is the name of the node, the internal ip by default.
+	Node string
+	// Prefix of the subjects, "push" by default.
+	Prefix string
+	// SyncInterval is the interval the snapshots of users are published at,
+	// the nodes not heard from in 3 intervals are removed from the index.
+	SyncInterval time.Duration
+}
+
+var defaultOptions = Options{
+	Prefix:       "push",
+	SyncInterval: 30 * time.Second,
+}
+
+// Option sets the options.
+type Option func(*Options)
+
+// Node sets the name of the node, it must be unique in the cluster.
+func Node(node string) Option {
+	return func(o *Options) {
+		o.Node = node
+	}
+}
+
+// Prefix sets the prefix of the subjects.
+func Prefix(prefix string) Option {
+	return func(o *Options) {
+		o.Prefix = prefix
+	}
+}
+
+// SyncInterval sets the interval the snapshots of users are published at.
+func SyncInterval(d time.Duration) Option {
+	return func(o *Options) {
+		o.SyncInterval = d
+	}
+}
+
+// presence is published to the presence subject, it is a snapshot of the
+// users of the node if Snapshot is set.
+type presence struct {
+	Node     string   `json:"node"`
+	User     string   `json:"user,omitempty"`
+	Online   bool     `json:"online,omitempty"`
+	Snapshot bool     `json:"snapshot,omitempty"`
+	Users    []string `json:"users,omitempty"`
+}
+
+// delivery is published to the node and user subjects.
+type delivery struct {
+	User string `json:"user"`
+	Data []byte `json:"data"` // anypb.Any
+}
+
+type node struct {
+	users map[string]struct{}
+	seen  time.Time
+}
+
+// Gateway routes the pushes to the nodes the users are connected to.
+type Gateway struct {
+	opts Options
+	conn *nats.Conn
+	hub  *hub.Hub
+	quit *event.Event
+	done chan struct{}
+
+	mu    sync.RWMutex
+	nodes map[string]*node               // remote nodes
+	users map[string]map[string]struct{} // user -> remote nodes
+	subs  map[string]*nats.Subscription  // user subjects of the local users
+	own   []*nats.Subscription
+
+	unnotify func() // removes sync from the hub
+}
+
+// New returns a gateway of the conns in h, conn is usually the one of the
+// xnats server, see xnats.Server.Conn.
+func New(conn *nats.Conn, h *hub.Hub, opt ...Option) (*Gateway, error) {
+	opts := defaultOptions
+	for _, o := range opt {
+		o(&opts)
+	}
+	if opts.Node == "" {
+		opts.Node = unats.IPSubject(ip.Internal())
+	}
+	g := &Gateway{
+		opts:  opts,
+		conn:  conn,
+		hub:   h,
+		quit:  event.NewEvent(),
+		done:  make(chan struct{}),
+		nodes: make(map[string]*node),
+		users: make(map[string]map[string]struct{}),
+		subs:  make(map[string]*nats.Subscription),
+	}
+	for subj, cb := range map[string]nats.MsgHandler{
+		g.subject("node", opts.Node): g.deliver,
+		g.subject("presence"):        g.presence,
+		g.subject("sync"):            func(*nats.Msg) { g.snapshot() },
+	} {
+		sub, err := conn.Subscribe(subj, cb)
+		if err != nil {
+			g.unsubscribe()
+			return nil, err
+		}
+		g.own = append(g.own, sub)
+	}
+	g.unnotify = h.Notify(g.sync)
+	for _, user := range h.Users() {
+		g.sync(user)
+	}
+	// ask the other nodes for their users
+	if err := conn.Publish(g.subject("sync"), nil); err != nil {
+		g.unnotify()
+		g.unsubscribe()
+		return nil, err
+	}
+	go g.run()
+	return g, nil
+}
+
+// subject joins the prefix and the tokens into a subject, the dots and
+// wildcards in the tokens are replaced.
+func (g *Gateway) subject(tokens ...string) string {
+	for i, t := range tokens {
+		tokens[i] = escaper.Replace(t)
+	}
+	return g.opts.Prefix + "." + strings.Join(tokens, ".")
+}
+
+var escaper = strings.NewReplacer(".", "-", "*", "-", ">", "-", " ", "-")
+
+// Node returns the name of the node.
+func (g *Gateway) Node() string {
+	return g.opts.Node
+}
+
+// Nodes returns the remote nodes the user is connected to by the index.
+func (g *Gateway) Nodes(userID string) []string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	nodes := make([]string, 0, len(g.users[userID]))
+	for n := range g.users[userID] {
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
+
+// Online reports whether the user is connected to any node.
+func (g *Gateway) Online(userID string) bool {
+	return len(g.hub.User(userID)) > 0 || len(g.Nodes(userID)) > 0
+}
+
+// Push writes msg to all the conns of the user in the cluster. The conns on
+// this node are written directly, the other nodes in the index are sent
+// msg on their node subjects. If the index does not know the user, which may
+// lag behind a fresh connect, msg is sent on the user subject instead.
+func (g *Gateway) Push(ctx context.Context, userID string, msg proto.Message) error {
+	if g.quit.HasFired() {
+		return ErrClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var local bool
+	if len(g.hub.User(userID)) > 0 {
+		local = true
+		if _, err := g.hub.SendToUser(userID, msg); err != nil {
+			return err
+		}
+	}
+	nodes := g.Nodes(userID)
+	if local && len(nodes) == 0 {
+		return nil
+	}
+	a, err := anypb.New(msg)
+	if err != nil {
+		return err
+	}
+	data, err := proto.Marshal(a)
+	if err != nil {
+		return err
+	}
+	data, err = json.Marshal(&delivery{User: userID, Data: data})
+	if err != nil {
+		return err
+	}
+	if len(nodes) == 0 {
+		return g.conn.Publish(g.subject("user", userID), data)
+	}
+	for _, n := range nodes {
+		if err = g.conn.Publish(g.subject("node", n), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *Gateway) deliver(m *nats.Msg) {
+	d := &delivery{}
+	if err := json.Unmarshal(m.Data, d); err != nil {
+		log.Errors("gateway: unmarshal delivery error", zap.String("subject", m.Subject), zap.Error(err))
+		return
+	}
+	a := &anypb.Any{}
+	if err := proto.Unmarshal(d.Data, a); err != nil {
+		log.Errors("gateway: unmarshal delivery error", zap.String("subject", m.Subject), zap.Error(err))
+		return
+	}
+	msg, err := a.UnmarshalNew()
+	if err != nil {
+		log.Errors("gateway: unmarshal delivery error", zap.String("type", a.TypeUrl), zap.Error(err))
+		return
+	}
+	if _, err = g.hub.SendToUser(d.User, msg); err != nil {
+		log.Errors("gateway: deliver error", zap.String("user", d.User), zap.Error(err))
+	}
+}
+
+// sync subscribes or unsubscribes the user subject by whether the user has
+// conns on this node, and publishes the change.
+func (g *Gateway) sync(userID string) {
+	online := len(g.hub.User(userID)) > 0
+	g.mu.Lock()
+	sub, subscribed := g.subs[userID]
+	switch {
+	case g.quit.HasFired() || online == subscribed:
+		g.mu.Unlock()
+		return
+	case online:
+		var err error
+		if sub, err = g.conn.Subscribe(g.subject("user", userID), g.deliver); err != nil {
+			g.mu.Unlock()
+			log.Errors("gateway: subscribe user error", zap.String("user", userID), zap.Error(err))
+			return
+		}
+		g.subs[userID] = sub
+	default:
+		delete(g.subs, userID)
+		if err := sub.Unsubscribe(); err != nil {
+			log.Errors("gateway: unsubscribe user error", zap.String("user", userID), zap.Error(err))
+		}
+	}
+	g.mu.Unlock()
+	if online {
+		// the pushes to the user subject are published once the user is
+		// online, so the server must know the subscription by then
+		if err := g.conn.Flush(); err != nil {
+			log.Errors("gateway: flush error", zap.String("user", userID), zap.Error(err))
+		}
+	}
+	g.publish(&presence{Node: g.opts.Node, User: userID, Online: online})
+}
+
+// snapshot publishes the users of this node.
+func (g *Gateway) snapshot() {
+	g.mu.RLock()
+	users := make([]string, 0, len(g.subs))
+	for user := range g.subs {
+		users = append(users, user)
+	}
+	g.mu.RUnlock()
+	g.publish(&presence{Node: g.opts.Node, Snapshot: true, Users: users})
+}
+
+func (g *Gateway) publish(p *presence) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		log.Errors("gateway: marshal presence error", zap.Error(err))
+		return
+	}
+	if err = g.conn.Publish(g.subject("presence"), data); err != nil {
+		log.Errors("gateway: publish presence error", zap.Error(err))
+	}
+}
+
+func (g *Gateway) presence(m *nats.Msg) {
+	p := &presence{}
+	if err := json.Unmarshal(m.Data, p); err != nil {
+		log.Errors("gateway: unmarshal presence error", zap.Error(err))
+		return
+	}
+	if p.Node == g.opts.Node {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	n, ok := g.nodes[p.Node]
+	if !ok {
+		n = &node{users: make(map[string]struct{})}
+		g.nodes[p.Node] = n
+	}
+	n.seen = time.Now()
+	if p.Snapshot {
+		for user := range n.users {
+			g.setUser(n, p.Node, user, false)
+		}
+		for _, user := range p.Users {
+			g.setUser(n, p.Node, user, true)
+		}
+		if len(n.users) == 0 {
+			delete(g.nodes, p.Node)
+		}
+		return
+	}
+	g.setUser(n, p.Node, p.User, p.Online)
+}
+
+// setUser updates the index, g.mu must be held.
+func (g *Gateway) setUser(n *node, name, user string, online bool) {
+	if online {
+		n.users[user] = struct{}{}
+		nodes, ok := g.users[user]
+		if !ok {
+			nodes = make(map[string]struct{})
+			g.users[user] = nodes
+		}
+		nodes[name] = struct{}{}
+		return
+	}
+	delete(n.users, user)
+	if nodes, ok := g.users[user]; ok {
+		delete(nodes, name)
+		if len(nodes) == 0 {
+			delete(g.users, user)
+		}
+	}
+}
+
+func (g *Gateway) run() {
+	defer close(g.done)
+	ticker := time.NewTicker(g.opts.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			g.snapshot()
+			g.expire(time.Now().Add(-3 * g.opts.SyncInterval))
+		case <-g.quit.Done():
+			return
+		}
+	}
+}
+
+// expire removes the nodes not heard from since deadline, e.g. the crashed
+// ones.
+func (g *Gateway) expire(deadline time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for name, n := range g.nodes {
+		if n.seen.After(deadline) {
+			continue
+		}
+		log.Infos("gateway: node expired", zap.String("node", name))
+		for user := range n.users {
+			g.setUser(n, name, user, false)
+		}
+		delete(g.nodes, name)
+	}
+}
+
+func (g *Gateway) unsubscribe() {
+	for _, sub := range g.own {
+		sub.Unsubscribe()
+	}
+}
+
+// Close unsubscribes all subjects and tells the other nodes that the users
+// of this node are offline. The nats conn is left open.
+func (g *Gateway) Close() {
+	if !g.quit.Fire() {
+		return
+	}
+	<-g.done
+	g.unnotify()
+	g.unsubscribe()
+	g.mu.Lock()
+	for user, sub := range g.subs {
+		sub.Unsubscribe()
+		delete(g.subs, user)
+	}
+	g.mu.Unlock()
+	g.publish(&presence{Node: g.opts.Node, Snapshot: true})
+	if err := g.conn.Flush(); err != nil {
+		log.Errors("gateway: flush error", zap.Error(err))
+	}
+}
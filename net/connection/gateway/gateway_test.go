@@ -0,0 +1,127 @@
+package gateway
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+	"github.com/xsuners/mo/net/connection"
+	"github.com/xsuners/mo/net/connection/hub"
+	"github.com/xsuners/mo/net/encoding"
+	"github.com/xsuners/mo/net/encoding/proto"
+	pb "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type user string
+
+func (u user) Disconnected()  {}
+func (u user) UserID() string { return string(u) }
+func (u user) Device() string { return "" }
+
+type conn struct {
+	id     int64
+	user   user
+	mu     sync.Mutex
+	writes int
+}
+
+func (c *conn) ID() int64 { return c.id }
+func (c *conn) Close()    {}
+func (c *conn) Write(data []byte) error {
+	c.mu.Lock()
+	c.writes++
+	c.mu.Unlock()
+	return nil
+}
+func (c *conn) WriteMessage(m pb.Message) error             { return nil }
+func (c *conn) User() connection.User                       { return c.user }
+func (c *conn) Codec() encoding.Codec                       { return encoding.GetCodec(proto.Name) }
+func (c *conn) RemoteAddr() net.Addr                        { return nil }
+func (c *conn) LocalAddr() net.Addr                         { return nil }
+func (c *conn) Heartbeat(ctx context.Context) error         { return nil }
+func (c *conn) Auth(context.Context, connection.User) error { return nil }
+func (c *conn) count() int                                  { c.mu.Lock(); defer c.mu.Unlock(); return c.writes }
+
+func eventually(t *testing.T, what string, f func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if f() {
+			return
+		}
+	}
+	t.Fatalf("%s is not reached", what)
+}
+
+func TestPush(t *testing.T) {
+	s := test.RunRandClientPortServer()
+	t.Cleanup(s.Shutdown)
+
+	newNode := func(name string) (*hub.Hub, *Gateway) {
+		nc, err := nats.Connect(s.ClientURL())
+		if err != nil {
+			t.Fatalf("nats.Connect() error = %v", err)
+		}
+		t.Cleanup(nc.Close)
+		h := hub.New()
+		g, err := New(nc, h, Node(name))
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		t.Cleanup(g.Close)
+		return h, g
+	}
+	h1, g1 := newNode("n1")
+	h2, g2 := newNode("n2")
+
+	c := &conn{id: 1, user: "u.1"}
+	h2.Add(c)
+	h2.Bind(c)
+	eventually(t, "presence of u.1 on n1", func() bool { return g1.Online("u.1") })
+	if nodes := g1.Nodes("u.1"); len(nodes) != 1 || nodes[0] != "n2" {
+		t.Errorf("Nodes(u.1) = %v", nodes)
+	}
+
+	msg := wrapperspb.String("hi")
+	if err := g1.Push(context.Background(), "u.1", msg); err != nil {
+		t.Fatalf("Push() error = %v", err)
+	}
+	eventually(t, "delivery through the node subject", func() bool { return c.count() == 1 })
+	if err := g2.Push(context.Background(), "u.1", msg); err != nil {
+		t.Fatalf("Push() local error = %v", err)
+	}
+	if c.count() != 2 {
+		t.Errorf("local writes = %d, want 2", c.count())
+	}
+
+	// a node joining later learns the users by sync
+	_, g3 := newNode("n3")
+	eventually(t, "presence of u.1 on n3", func() bool { return g3.Online("u.1") })
+
+	// the user subject is used when the index does not know the user
+	other := &conn{id: 2, user: "u2"}
+	subs := s.NumSubscriptions()
+	h1.Add(other)
+	h1.Bind(other)
+	// flushed by Bind already
+	if n := s.NumSubscriptions(); n != subs+1 {
+		t.Fatalf("subscriptions = %d, want %d", n, subs+1)
+	}
+	if err := g3.Push(context.Background(), "u2", msg); err != nil {
+		t.Fatalf("Push() error = %v", err)
+	}
+	eventually(t, "delivery through the user subject", func() bool { return other.count() == 1 })
+
+	h2.Remove(c)
+	eventually(t, "offline of u.1 on n1", func() bool { return !g1.Online("u.1") })
+
+	g1.Close()
+	eventually(t, "expiry of n1 on n3", func() bool { return !g3.Online("u2") })
+	if err := g1.Push(context.Background(), "u2", msg); err != ErrClosed {
+		t.Errorf("Push() after close error = %v, want %v", err, ErrClosed)
+	}
+}
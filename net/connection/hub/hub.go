@@ -0,0 +1,291 @@
+// Package hub indexes the long connections of the xtcp and xws servers by
+// user and group, and pushes messages to them.
+//
+//	h := hub.New()
+//	mo.TCP(xtcp.Hub(h)), mo.WS(xws.Hub(h))
+//	...
+//	h.Join(conn, "room-1")
+//	h.SendToGroup("room-1", msg)
+//
+// The conns are added on connect, indexed by user once they are authed with
+// a user implementing Identity, and removed with their groups on close.
+package hub
+
+import (
+	"sync"
+
+	"github.com/xsuners/mo/log"
+	"github.com/xsuners/mo/net/connection"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+// Identity is implemented by the users of connection.Conn which can be
+// looked up in the hub.
+type Identity interface {
+	// UserID returns the id of the user.
+	UserID() string
+	// Device returns the device the user connects from, e.g. "ios", it can be
+	// empty if a user connects from one device only.
+	Device() string
+}
+
+// Hub is an index of conns, it is safe for concurrent use.
+type Hub struct {
+	mu     sync.RWMutex
+	conns  map[int64]*entry
+	users  map[string]map[int64]*entry
+	groups map[string]map[int64]*entry
+	// notifiers is replaced rather than modified, so that a copy of it can
+	// be called without the lock.
+	notifiers []*notifier
+}
+
+type notifier struct {
+	f func(userID string)
+}
+
+type entry struct {
+	conn   connection.Conn
+	user   string
+	device string
+	groups map[string]struct{}
+}
+
+// New returns an empty hub.
+func New() *Hub {
+	return &Hub{
+		conns:  make(map[int64]*entry),
+		users:  make(map[string]map[int64]*entry),
+		groups: make(map[string]map[int64]*entry),
+	}
+}
+
+// Add adds c, it is called by the servers on connect.
+func (h *Hub) Add(c connection.Conn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.conns[c.ID()]; ok {
+		return
+	}
+	h.conns[c.ID()] = &entry{conn: c, groups: make(map[string]struct{})}
+}
+
+// Notify adds f to be called after a user gets its first conn or loses its
+// last one, f checks User for the current conns since the calls may race.
+// remove removes f.
+func (h *Hub) Notify(f func(userID string)) (remove func()) {
+	n := &notifier{f: f}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.notifiers = append(h.notifiers, n)
+	return func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		notifiers := make([]*notifier, 0, len(h.notifiers))
+		for _, other := range h.notifiers {
+			if other != n {
+				notifiers = append(notifiers, other)
+			}
+		}
+		h.notifiers = notifiers
+	}
+}
+
+func notify(notifiers []*notifier, userID string) {
+	for _, n := range notifiers {
+		n.f(userID)
+	}
+}
+
+// Bind indexes c by its user, it is called by the servers once c is authed.
+// It is a no-op if c is not added or its user does not implement Identity.
+func (h *Hub) Bind(c connection.Conn) {
+	id, ok := c.User().(Identity)
+	if !ok {
+		return
+	}
+	h.mu.Lock()
+	e, ok := h.conns[c.ID()]
+	if !ok || e.user != "" {
+		h.mu.Unlock()
+		return
+	}
+	e.user, e.device = id.UserID(), id.Device()
+	first := len(h.users[e.user]) == 0
+	add(h.users, e.user, c.ID(), e)
+	notifiers := h.notifiers
+	h.mu.Unlock()
+	if first {
+		notify(notifiers, e.user)
+	}
+}
+
+// Remove removes c from the hub and its groups, it is called by the servers
+// on close.
+func (h *Hub) Remove(c connection.Conn) {
+	h.mu.Lock()
+	e, ok := h.conns[c.ID()]
+	if !ok {
+		h.mu.Unlock()
+		return
+	}
+	delete(h.conns, c.ID())
+	var last bool
+	if e.user != "" {
+		remove(h.users, e.user, c.ID())
+		last = len(h.users[e.user]) == 0
+	}
+	for group := range e.groups {
+		remove(h.groups, group, c.ID())
+	}
+	notifiers := h.notifiers
+	h.mu.Unlock()
+	if last {
+		notify(notifiers, e.user)
+	}
+}
+
+// Join adds c to the group, c must be added already.
+func (h *Hub) Join(c connection.Conn, group string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e, ok := h.conns[c.ID()]
+	if !ok {
+		return false
+	}
+	e.groups[group] = struct{}{}
+	add(h.groups, group, c.ID(), e)
+	return true
+}
+
+// Leave removes c from the group.
+func (h *Hub) Leave(c connection.Conn, group string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if e, ok := h.conns[c.ID()]; ok {
+		delete(e.groups, group)
+		remove(h.groups, group, c.ID())
+	}
+}
+
+// Len returns the number of conns.
+func (h *Hub) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.conns)
+}
+
+// Users returns the ids of the users having conns.
+func (h *Hub) Users() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	users := make([]string, 0, len(h.users))
+	for user := range h.users {
+		users = append(users, user)
+	}
+	return users
+}
+
+// User returns the conns of the user.
+func (h *Hub) User(userID string) []connection.Conn {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return conns(h.users[userID])
+}
+
+// Device returns the conn of the user from the device, nil if there is
+// none.
+func (h *Hub) Device(userID, device string) connection.Conn {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, e := range h.users[userID] {
+		if e.device == device {
+			return e.conn
+		}
+	}
+	return nil
+}
+
+// Group returns the conns in the group.
+func (h *Hub) Group(group string) []connection.Conn {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return conns(h.groups[group])
+}
+
+// SendToUser writes msg to all the conns of the user, it returns the number
+// of conns written.
+func (h *Hub) SendToUser(userID string, msg proto.Message) (int, error) {
+	return send(h.User(userID), msg)
+}
+
+// SendToGroup writes msg to all the conns in the group, it returns the
+// number of conns written.
+func (h *Hub) SendToGroup(group string, msg proto.Message) (int, error) {
+	return send(h.Group(group), msg)
+}
+
+// Broadcast writes msg to all the conns, it returns the number of conns
+// written.
+func (h *Hub) Broadcast(msg proto.Message) (int, error) {
+	h.mu.RLock()
+	all := make([]connection.Conn, 0, len(h.conns))
+	for _, e := range h.conns {
+		all = append(all, e.conn)
+	}
+	h.mu.RUnlock()
+	return send(all, msg)
+}
+
+// send marshals msg once per codec and writes it to the conns. The conns
+// failed to write, e.g. the slow ones, are skipped. It fails only if msg
+// can not be marshalled.
+func send(conns []connection.Conn, msg proto.Message) (n int, err error) {
+	encoded := make(map[string][]byte)
+	for _, c := range conns {
+		codec := c.Codec()
+		if codec == nil { // handshake is not done
+			continue
+		}
+		data, ok := encoded[codec.Name()]
+		if !ok {
+			if data, err = codec.Marshal(msg); err != nil {
+				return
+			}
+			encoded[codec.Name()] = data
+		}
+		if werr := c.Write(data); werr != nil {
+			log.Debugs("hub: write error", zap.Int64("conn", c.ID()), zap.Error(werr))
+			continue
+		}
+		n++
+	}
+	return
+}
+
+func add(index map[string]map[int64]*entry, key string, id int64, e *entry) {
+	m, ok := index[key]
+	if !ok {
+		m = make(map[int64]*entry)
+		index[key] = m
+	}
+	m[id] = e
+}
+
+func remove(index map[string]map[int64]*entry, key string, id int64) {
+	if m, ok := index[key]; ok {
+		delete(m, id)
+		if len(m) == 0 {
+			delete(index, key)
+		}
+	}
+}
+
+func conns(m map[int64]*entry) []connection.Conn {
+	cs := make([]connection.Conn, 0, len(m))
+	for _, e := range m {
+		cs = append(cs, e.conn)
+	}
+	return cs
+}
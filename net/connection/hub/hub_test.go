@@ -0,0 +1,111 @@
+package hub
+
+import (
+	"context"
+	"net"
+	"sort"
+	"testing"
+
+	"github.com/xsuners/mo/net/connection"
+	"github.com/xsuners/mo/net/encoding"
+	"github.com/xsuners/mo/net/encoding/json"
+	"github.com/xsuners/mo/net/encoding/proto"
+	pb "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type user struct{ id, device string }
+
+func (u user) Disconnected()  {}
+func (u user) UserID() string { return u.id }
+func (u user) Device() string { return u.device }
+
+type conn struct {
+	id     int64
+	user   connection.User
+	codec  encoding.Codec
+	writes [][]byte
+}
+
+func (c *conn) ID() int64                                   { return c.id }
+func (c *conn) Close()                                      {}
+func (c *conn) Write(data []byte) error                     { c.writes = append(c.writes, data); return nil }
+func (c *conn) WriteMessage(m pb.Message) error             { return nil }
+func (c *conn) User() connection.User                       { return c.user }
+func (c *conn) Codec() encoding.Codec                       { return c.codec }
+func (c *conn) RemoteAddr() net.Addr                        { return nil }
+func (c *conn) LocalAddr() net.Addr                         { return nil }
+func (c *conn) Heartbeat(ctx context.Context) error         { return nil }
+func (c *conn) Auth(context.Context, connection.User) error { return nil }
+
+func ids(conns []connection.Conn) (ids []int64) {
+	for _, c := range conns {
+		ids = append(ids, c.ID())
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return
+}
+
+func TestHub(t *testing.T) {
+	h := New()
+	a1 := &conn{id: 1, user: user{"a", "ios"}, codec: encoding.GetCodec(proto.Name)}
+	a2 := &conn{id: 2, user: user{"a", "web"}, codec: encoding.GetCodec(json.Name)}
+	b := &conn{id: 3, user: user{"b", ""}, codec: encoding.GetCodec(proto.Name)}
+	anon := &conn{id: 4, codec: encoding.GetCodec(proto.Name)}
+	for _, c := range []*conn{a1, a2, b, anon} {
+		h.Add(c)
+		h.Bind(c)
+	}
+	if got := ids(h.User("a")); len(got) != 2 {
+		t.Errorf("User(a) = %v", got)
+	}
+	if c := h.Device("a", "web"); c != a2 {
+		t.Errorf("Device(a, web) = %v", c)
+	}
+	h.Join(a1, "room")
+	h.Join(b, "room")
+	h.Join(anon, "room")
+	h.Leave(anon, "room")
+
+	msg := wrapperspb.String("hi")
+	if n, err := h.SendToGroup("room", msg); n != 2 || err != nil {
+		t.Errorf("SendToGroup() = %d, %v", n, err)
+	}
+	if n, err := h.SendToUser("a", msg); n != 2 || err != nil {
+		t.Errorf("SendToUser() = %d, %v", n, err)
+	}
+	if n, err := h.Broadcast(msg); n != 4 || err != nil {
+		t.Errorf("Broadcast() = %d, %v", n, err)
+	}
+	if len(a1.writes) != 3 || len(a2.writes) != 2 {
+		t.Errorf("writes = %d, %d", len(a1.writes), len(a2.writes))
+	}
+
+	h.Remove(a1)
+	h.Remove(b)
+	if got := ids(h.Group("room")); len(got) != 0 {
+		t.Errorf("Group(room) after remove = %v", got)
+	}
+	if got := ids(h.User("a")); len(got) != 1 || got[0] != 2 {
+		t.Errorf("User(a) after remove = %v", got)
+	}
+	if h.Len() != 2 {
+		t.Errorf("Len() = %d", h.Len())
+	}
+}
+
+func TestNotify(t *testing.T) {
+	h := New()
+	var first, second []string
+	removeFirst := h.Notify(func(userID string) { first = append(first, userID) })
+	h.Notify(func(userID string) { second = append(second, userID) })
+
+	a := &conn{id: 1, user: user{"a", ""}}
+	h.Add(a)
+	h.Bind(a)
+	removeFirst()
+	h.Remove(a)
+	if len(first) != 1 || len(second) != 2 {
+		t.Errorf("notified first %v, second %v, want 1 and 2 calls", first, second)
+	}
+}
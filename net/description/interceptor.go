@@ -81,3 +81,67 @@ type StreamServerInfo struct {
 // service method implementation. It is the responsibility of the interceptor to invoke handler to
 // complete the RPC.
 type StreamServerInterceptor func(srv interface{}, ss ServerStream, info *StreamServerInfo, handler StreamHandler) error
+
+// ChainUnaryServer chains the unary server interceptors into one. The first
+// interceptor will be the outer most, while the last interceptor will be the
+// inner most wrapper around the real call. Nil interceptors are skipped, and
+// nil is returned if there is no interceptor at all.
+func ChainUnaryServer(interceptors ...UnaryServerInterceptor) UnaryServerInterceptor {
+	var ints []UnaryServerInterceptor
+	for _, i := range interceptors {
+		if i != nil {
+			ints = append(ints, i)
+		}
+	}
+	switch len(ints) {
+	case 0:
+		return nil
+	case 1:
+		return ints[0]
+	}
+	return func(ctx context.Context, req interface{}, info *UnaryServerInfo, handler UnaryHandler) (interface{}, error) {
+		return ints[0](ctx, req, info, chainUnaryHandler(ints, 0, info, handler))
+	}
+}
+
+// chainUnaryHandler recursively generate the chained UnaryHandler
+func chainUnaryHandler(interceptors []UnaryServerInterceptor, curr int, info *UnaryServerInfo, finalHandler UnaryHandler) UnaryHandler {
+	if curr == len(interceptors)-1 {
+		return finalHandler
+	}
+	return func(ctx context.Context, req interface{}) (interface{}, error) {
+		return interceptors[curr+1](ctx, req, info, chainUnaryHandler(interceptors, curr+1, info, finalHandler))
+	}
+}
+
+// ChainUnaryClient chains the unary client interceptors into one. The first
+// interceptor will be the outer most, while the last interceptor will be the
+// inner most wrapper around the real call. Nil interceptors are skipped, and
+// nil is returned if there is no interceptor at all.
+func ChainUnaryClient(interceptors ...UnaryClientInterceptor) UnaryClientInterceptor {
+	var ints []UnaryClientInterceptor
+	for _, i := range interceptors {
+		if i != nil {
+			ints = append(ints, i)
+		}
+	}
+	switch len(ints) {
+	case 0:
+		return nil
+	case 1:
+		return ints[0]
+	}
+	return func(ctx context.Context, method string, req, reply interface{}, cc UnaryClient, invoker UnaryInvoker, opts ...CallOption) error {
+		return ints[0](ctx, method, req, reply, cc, chainUnaryInvoker(ints, 0, invoker), opts...)
+	}
+}
+
+// chainUnaryInvoker recursively generate the chained unary invoker.
+func chainUnaryInvoker(interceptors []UnaryClientInterceptor, curr int, finalInvoker UnaryInvoker) UnaryInvoker {
+	if curr == len(interceptors)-1 {
+		return finalInvoker
+	}
+	return func(ctx context.Context, method string, req, reply interface{}, cc UnaryClient, opts ...CallOption) error {
+		return interceptors[curr+1](ctx, method, req, reply, cc, chainUnaryInvoker(interceptors, curr+1, finalInvoker), opts...)
+	}
+}
@@ -0,0 +1,62 @@
+package description
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestChainUnaryServer(t *testing.T) {
+	var order []string
+	newInt := func(name string) UnaryServerInterceptor {
+		return func(ctx context.Context, req interface{}, info *UnaryServerInfo, handler UnaryHandler) (interface{}, error) {
+			order = append(order, name)
+			return handler(ctx, req)
+		}
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		order = append(order, "handler")
+		return req, nil
+	}
+
+	tests := []struct {
+		name string
+		ints []UnaryServerInterceptor
+		want []string
+	}{
+		{
+			name: "none",
+			ints: []UnaryServerInterceptor{nil},
+			want: nil,
+		},
+		{
+			name: "one",
+			ints: []UnaryServerInterceptor{nil, newInt("a")},
+			want: []string{"a", "handler"},
+		},
+		{
+			name: "outer most first",
+			ints: []UnaryServerInterceptor{newInt("a"), nil, newInt("b"), newInt("c")},
+			want: []string{"a", "b", "c", "handler"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order = nil
+			chained := ChainUnaryServer(tt.ints...)
+			if tt.want == nil {
+				if chained != nil {
+					t.Fatal("ChainUnaryServer() != nil, want nil")
+				}
+				return
+			}
+			out, err := chained(context.Background(), "req", &UnaryServerInfo{}, handler)
+			if err != nil || out != "req" {
+				t.Fatalf("chained() = %v, %v, want req, nil", out, err)
+			}
+			if !reflect.DeepEqual(order, tt.want) {
+				t.Errorf("order = %v, want %v", order, tt.want)
+			}
+		})
+	}
+}
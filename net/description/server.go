@@ -1,9 +1,41 @@
 package description
 
-import "github.com/xsuners/mo/naming"
+import (
+	"context"
+
+	"github.com/xsuners/mo/naming"
+)
 
 type Server interface {
 	Register(ss interface{}, sds ...*ServiceDesc)
 	Serve() error
 	Naming(naming naming.Naming) error
 }
+
+// Readier is implemented by servers which can tell when they are able to
+// accept requests, e.g. once their listener is bound.
+type Readier interface {
+	// Ready returns a channel that is closed when the server is ready.
+	Ready() <-chan struct{}
+}
+
+// Drainer is implemented by servers which can finish in-flight requests
+// before being stopped.
+type Drainer interface {
+	// Drain stops accepting new requests and blocks until all in-flight
+	// requests are done or ctx is done, whichever comes first.
+	Drain(ctx context.Context) error
+}
+
+// Porter is implemented by servers which listen on a port.
+type Porter interface {
+	// Port returns the listening port, which is the one picked by the system
+	// once the server is ready if 0 is configured.
+	Port() int
+}
+
+// Lister is implemented by servers which can list their registered services.
+type Lister interface {
+	// Services returns the registered services keyed by service name.
+	Services() map[string]*ServiceInfo
+}
@@ -0,0 +1,64 @@
+package encoding
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// ErrTooLarge is returned by Decompress when the decompressed data exceeds
+// the limit.
+var ErrTooLarge = errors.New("encoding: decompressed data too large")
+
+// Compress returns data compressed by c.
+func Compress(c Compressor, data []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	w, err := c.Compress(buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(data); err != nil {
+		w.Close()
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress returns data decompressed by c, it fails with ErrTooLarge if
+// the result exceeds max bytes, max <= 0 means no limit.
+func Decompress(c Compressor, data []byte, max int) ([]byte, error) {
+	r, err := c.Decompress(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if max <= 0 {
+		return ioutil.ReadAll(r)
+	}
+	out, err := ioutil.ReadAll(io.LimitReader(r, int64(max)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > max {
+		return nil, ErrTooLarge
+	}
+	return out, nil
+}
+
+// SelectCompressor returns the first compressor of offered which is in
+// allowed and registered, or nil if there is none.
+func SelectCompressor(offered, allowed []string) Compressor {
+	for _, name := range offered {
+		for _, a := range allowed {
+			if name == a {
+				if c := GetCompressor(name); c != nil {
+					return c
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+// Package snappy implements and registers the snappy compressor, it is much
+// faster than gzip with a lower ratio.
+package snappy
+
+import (
+	"io"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/xsuners/mo/net/encoding"
+)
+
+// Name is the name registered for the snappy compressor.
+const Name = "snappy"
+
+func init() {
+	encoding.RegisterCompressor(&compressor{})
+}
+
+type compressor struct {
+	poolCompressor   sync.Pool
+	poolDecompressor sync.Pool
+}
+
+type writer struct {
+	*snappy.Writer
+	pool *sync.Pool
+}
+
+func (c *compressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	z, ok := c.poolCompressor.Get().(*writer)
+	if !ok {
+		return &writer{Writer: snappy.NewBufferedWriter(w), pool: &c.poolCompressor}, nil
+	}
+	z.Reset(w)
+	return z, nil
+}
+
+func (z *writer) Close() error {
+	defer z.pool.Put(z)
+	return z.Writer.Close()
+}
+
+func (c *compressor) Decompress(r io.Reader) (io.Reader, error) {
+	z, ok := c.poolDecompressor.Get().(*snappy.Reader)
+	if !ok {
+		z = snappy.NewReader(r)
+	} else {
+		z.Reset(r)
+	}
+	return &reader{Reader: z, pool: &c.poolDecompressor}, nil
+}
+
+type reader struct {
+	*snappy.Reader
+	pool *sync.Pool
+}
+
+func (z *reader) Read(p []byte) (n int, err error) {
+	n, err = z.Reader.Read(p)
+	if err == io.EOF {
+		z.pool.Put(z.Reader)
+	}
+	return n, err
+}
+
+func (c *compressor) Name() string {
+	return Name
+}
@@ -0,0 +1,47 @@
+package message
+
+// ControlService is the service of the control messages, which are sent by
+// the long connection servers to the clients rather than to any handler.
+const ControlService = "mo"
+
+// MethodGoAway is the method of the message telling the client that the
+// server is draining. The client should stop sending new requests over the
+// conn and reconnect, possibly to another instance; the requests in process
+// are still replied before the conn is closed.
+const MethodGoAway = "goaway"
+
+// GoAway returns a GOAWAY control message.
+func GoAway(desc string) *Message {
+	return &Message{
+		Service: ControlService,
+		Method:  MethodGoAway,
+		Desc:    desc,
+	}
+}
+
+// IsGoAway reports whether m is a GOAWAY control message.
+func IsGoAway(m *Message) bool {
+	return m.Service == ControlService && m.Method == MethodGoAway
+}
+
+// MethodPing is the method of the message the server sends to probe an idle
+// conn, the client replies MethodPong.
+const MethodPing = "ping"
+
+// MethodPong is the method of the reply of MethodPing.
+const MethodPong = "pong"
+
+// Ping returns a ping control message.
+func Ping() *Message {
+	return &Message{Service: ControlService, Method: MethodPing}
+}
+
+// Pong returns a pong control message.
+func Pong() *Message {
+	return &Message{Service: ControlService, Method: MethodPong}
+}
+
+// IsControl reports whether m is a control message.
+func IsControl(m *Message) bool {
+	return m.Service == ControlService
+}
@@ -0,0 +1,158 @@
+package message
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/bits"
+	"sync"
+)
+
+// DefaultBufferSize is the size of the buffers of FrameReader and
+// FrameWriter.
+const DefaultBufferSize = 4096
+
+// ErrFrameTooLarge is returned when a frame is over the max size.
+var ErrFrameTooLarge = errors.New("codec: message length over max bytes")
+
+// The payload buffers are pooled in classes of powers of two from 512B to
+// MaxBytes, the larger ones are not pooled.
+const (
+	minClassBits = 9
+	maxClassBits = 23
+)
+
+var pools [maxClassBits - minClassBits + 1]sync.Pool
+
+func class(n int) int {
+	if n <= 1<<minClassBits {
+		return 0
+	}
+	return bits.Len(uint(n-1)) - minClassBits
+}
+
+// getBuffer returns a buffer of n bytes, whose capacity is a power of two.
+func getBuffer(n int) *[]byte {
+	c := class(n)
+	if c >= len(pools) {
+		b := make([]byte, n)
+		return &b
+	}
+	if v := pools[c].Get(); v != nil {
+		b := v.(*[]byte)
+		*b = (*b)[:n]
+		return b
+	}
+	b := make([]byte, n, 1<<(c+minClassBits))
+	return &b
+}
+
+func putBuffer(b *[]byte) {
+	c := class(cap(*b))
+	if c >= len(pools) || cap(*b) != 1<<(c+minClassBits) {
+		return
+	}
+	pools[c].Put(b)
+}
+
+// FrameReader reads frames from a buffered reader, the payload buffers are
+// taken from a pool and reused, see ReadFrame.
+type FrameReader struct {
+	r      *bufio.Reader
+	max    int
+	buf    *[]byte
+	header [_messageLenBytes]byte
+}
+
+// NewFrameReader returns a reader of the frames from r, whose payload has max
+// bytes at most, MaxBytes if max is not positive.
+func NewFrameReader(r io.Reader, max int) *FrameReader {
+	if max <= 0 {
+		max = MaxBytes
+	}
+	return &FrameReader{
+		r:   bufio.NewReaderSize(r, DefaultBufferSize),
+		max: max,
+	}
+}
+
+// ReadFrame reads the next frame, it reports whether the payload is
+// compressed. The payload is valid until the next call of ReadFrame or
+// Release, the callers must copy what they keep.
+func (fr *FrameReader) ReadFrame() (data []byte, compressed bool, err error) {
+	fr.Release()
+	if _, err = io.ReadFull(fr.r, fr.header[:]); err != nil {
+		return
+	}
+	n := binary.LittleEndian.Uint32(fr.header[:])
+	compressed = n&FlagCompressed != 0
+	n &^= FlagCompressed
+	if int64(n) > int64(fr.max) {
+		return nil, false, ErrFrameTooLarge
+	}
+	fr.buf = getBuffer(int(n))
+	if _, err = io.ReadFull(fr.r, *fr.buf); err != nil {
+		fr.Release()
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, false, err
+	}
+	return *fr.buf, compressed, nil
+}
+
+// Max returns the max payload size.
+func (fr *FrameReader) Max() int {
+	return fr.max
+}
+
+// Release returns the payload buffer of the last frame to the pool.
+func (fr *FrameReader) Release() {
+	if fr.buf != nil {
+		putBuffer(fr.buf)
+		fr.buf = nil
+	}
+}
+
+// FrameWriter writes frames to a buffered writer, the frames are sent on
+// Flush, or once the buffer is full, so the small frames written in a row
+// are batched into one write.
+type FrameWriter struct {
+	w      *bufio.Writer
+	header [_messageLenBytes]byte
+}
+
+// NewFrameWriter returns a writer of frames to w.
+func NewFrameWriter(w io.Writer) *FrameWriter {
+	return &FrameWriter{
+		w: bufio.NewWriterSize(w, DefaultBufferSize),
+	}
+}
+
+// WriteFrame writes a frame of data to the buffer.
+func (fw *FrameWriter) WriteFrame(data []byte, compressed bool) error {
+	if len(data) >= FlagCompressed {
+		return ErrFrameTooLarge
+	}
+	n := uint32(len(data))
+	if compressed {
+		n |= FlagCompressed
+	}
+	binary.LittleEndian.PutUint32(fw.header[:], n)
+	if _, err := fw.w.Write(fw.header[:]); err != nil {
+		return err
+	}
+	_, err := fw.w.Write(data)
+	return err
+}
+
+// Buffered returns the number of bytes not flushed yet.
+func (fw *FrameWriter) Buffered() int {
+	return fw.w.Buffered()
+}
+
+// Flush writes the buffered frames to the underlying writer.
+func (fw *FrameWriter) Flush() error {
+	return fw.w.Flush()
+}
@@ -0,0 +1,162 @@
+package message
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestFrame(t *testing.T) {
+	var buf bytes.Buffer
+	fw := NewFrameWriter(&buf)
+	payloads := [][]byte{{}, []byte("a"), bytes.Repeat([]byte("b"), 600), bytes.Repeat([]byte("c"), 2*DefaultBufferSize)}
+	for i, p := range payloads {
+		if i == len(payloads)-1 && buf.Len() != 0 {
+			t.Errorf("small frames written before flush = %d bytes", buf.Len())
+		}
+		if err := fw.WriteFrame(p, i%2 == 1); err != nil {
+			t.Fatalf("WriteFrame() error = %v", err)
+		}
+	}
+	if err := fw.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+
+	fr := NewFrameReader(&buf, 0)
+	for i, p := range payloads {
+		data, compressed, err := fr.ReadFrame()
+		if err != nil {
+			t.Fatalf("ReadFrame() error = %v", err)
+		}
+		if !bytes.Equal(data, p) || compressed != (i%2 == 1) {
+			t.Errorf("frame %d = %d bytes, compressed %v", i, len(data), compressed)
+		}
+	}
+	if _, _, err := fr.ReadFrame(); err != io.EOF {
+		t.Errorf("ReadFrame() at end error = %v, want EOF", err)
+	}
+}
+
+func TestFrameTooLarge(t *testing.T) {
+	fr := NewFrameReader(bytes.NewReader(EncodeFrame(make([]byte, 11), false)), 10)
+	if _, _, err := fr.ReadFrame(); err != ErrFrameTooLarge {
+		t.Errorf("ReadFrame() error = %v, want %v", err, ErrFrameTooLarge)
+	}
+	fr = NewFrameReader(bytes.NewReader(EncodeFrame(make([]byte, 10), false)[:8]), 10)
+	if _, _, err := fr.ReadFrame(); err != io.ErrUnexpectedEOF {
+		t.Errorf("ReadFrame() of a short frame error = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+// frames returns a reader repeating n frames of size bytes.
+func frames(n, size int) *bytes.Reader {
+	frame := EncodeFrame(make([]byte, size), false)
+	return bytes.NewReader(bytes.Repeat(frame, n))
+}
+
+// decodeChan is the Decode replaced by FrameReader, which read the length
+// header in a goroutine per frame, kept as the baseline of the benchmarks.
+func decodeChan(raw io.Reader) ([]byte, error) {
+	ch := make(chan []byte)
+	ech := make(chan error)
+
+	go func(bc chan []byte, ec chan error) {
+		lengthBytes := make([]byte, _messageLenBytes)
+		_, err := io.ReadFull(raw, lengthBytes)
+		if err != nil {
+			ec <- err
+			close(bc)
+			close(ec)
+			return
+		}
+		bc <- lengthBytes
+	}(ch, ech)
+
+	select {
+	case err := <-ech:
+		return nil, err
+	case lengthBytes := <-ch:
+		var msgLen uint32
+		if err := binary.Read(bytes.NewReader(lengthBytes), binary.LittleEndian, &msgLen); err != nil {
+			return nil, err
+		}
+		if msgLen > _messageMaxBytes {
+			return nil, errors.New("codec: message length over max bytes")
+		}
+		msgBytes := make([]byte, msgLen)
+		if _, err := io.ReadFull(raw, msgBytes); err != nil {
+			return nil, err
+		}
+		return msgBytes, nil
+	}
+}
+
+func BenchmarkDecodeChan(b *testing.B) {
+	r := frames(b.N, 256)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := decodeChan(r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeFrame(b *testing.B) {
+	r := frames(b.N, 256)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := DecodeFrame(r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFrameReader(b *testing.B) {
+	fr := NewFrameReader(frames(b.N, 256), 0)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := fr.ReadFrame(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// conn counts the writes to it, like the syscalls of a net.Conn.
+type conn struct{ writes int }
+
+func (c *conn) Write(p []byte) (int, error) {
+	c.writes++
+	return len(p), nil
+}
+
+func BenchmarkEncodeFrame(b *testing.B) {
+	c := &conn{}
+	data := make([]byte, 256)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c.Write(EncodeFrame(data, false))
+	}
+	b.ReportMetric(float64(c.writes)/float64(b.N), "writes/op")
+}
+
+func BenchmarkFrameWriter(b *testing.B) {
+	c := &conn{}
+	fw := NewFrameWriter(c)
+	data := make([]byte, 256)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := fw.WriteFrame(data, false); err != nil {
+			b.Fatal(err)
+		}
+		if i%16 == 15 { // a burst of 16 frames queued
+			fw.Flush()
+		}
+	}
+	fw.Flush()
+	b.ReportMetric(float64(c.writes)/float64(b.N), "writes/op")
+}
@@ -1,9 +1,7 @@
 package message
 
 import (
-	"bytes"
 	"encoding/binary"
-	"errors"
 	"io"
 
 	"google.golang.org/grpc/metadata"
@@ -14,45 +12,62 @@ const (
 	_messageMaxBytes = 1 << 23 // 8M
 )
 
-// Decode decodes the bytes data into Message
-func Decode(raw io.Reader) ([]byte, error) {
-	ch := make(chan []byte)
-	ech := make(chan error)
-
-	go func(bc chan []byte, ec chan error) {
-		lengthBytes := make([]byte, _messageLenBytes)
-		_, err := io.ReadFull(raw, lengthBytes)
-		if err != nil {
-			ec <- err
-			close(bc)
-			close(ec)
-			return
-		}
-		bc <- lengthBytes
-	}(ch, ech)
+// MaxBytes is the max size of a frame payload.
+const MaxBytes = _messageMaxBytes
 
-	select {
-	case err := <-ech:
+// Decode reads a frame from raw and returns its payload, the frames flagged
+// compressed are rejected. The long lived conns should use FrameReader,
+// which reuses the buffers.
+func Decode(raw io.Reader) ([]byte, error) {
+	var header [_messageLenBytes]byte
+	if _, err := io.ReadFull(raw, header[:]); err != nil {
 		return nil, err
-	case lengthBytes := <-ch:
-		lengthBuf := bytes.NewReader(lengthBytes)
-		var msgLen uint32
-		err := binary.Read(lengthBuf, binary.LittleEndian, &msgLen)
-		if err != nil {
-			return nil, err
-		}
-		if msgLen > _messageMaxBytes {
-			// log.Errorf("message has bytes(%d) beyond max %d", msgLen, _messageMaxBytes)
-			return nil, errors.New("codec: message length over max bytes")
-		}
+	}
+	n := binary.LittleEndian.Uint32(header[:])
+	if n > _messageMaxBytes {
+		return nil, ErrFrameTooLarge
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(raw, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
 
-		msgBytes := make([]byte, msgLen)
-		if _, err = io.ReadFull(raw, msgBytes); err != nil {
-			return nil, err
-		}
+// FlagCompressed is set in the length header of the frames whose payload is
+// compressed, the frames are never flagged unless a compressor is negotiated.
+const FlagCompressed = 1 << 31
+
+// DecodeFrame reads a frame from raw, it reports whether the payload is
+// compressed.
+func DecodeFrame(raw io.Reader) (data []byte, compressed bool, err error) {
+	var header [_messageLenBytes]byte
+	if _, err = io.ReadFull(raw, header[:]); err != nil {
+		return
+	}
+	n := binary.LittleEndian.Uint32(header[:])
+	compressed = n&FlagCompressed != 0
+	n &^= FlagCompressed
+	if n > _messageMaxBytes {
+		return nil, false, ErrFrameTooLarge
+	}
+	data = make([]byte, n)
+	if _, err = io.ReadFull(raw, data); err != nil {
+		return nil, false, err
+	}
+	return
+}
 
-		return msgBytes, nil
+// EncodeFrame returns data prefixed with the length header.
+func EncodeFrame(data []byte, compressed bool) []byte {
+	frame := make([]byte, _messageLenBytes+len(data))
+	n := uint32(len(data))
+	if compressed {
+		n |= FlagCompressed
 	}
+	binary.LittleEndian.PutUint32(frame, n)
+	copy(frame[_messageLenBytes:], data)
+	return frame
 }
 
 // // Encode encodes the message into bytes data.
@@ -0,0 +1,328 @@
+// Package proxyproto parses the PROXY protocol v1 and v2 headers sent by
+// load balancers ahead of the streams, so that the conns report the
+// addresses of the clients instead of the balancers.
+//
+//	trusted, err := proxyproto.ParseCIDRs("10.0.0.0/8")
+//	l = proxyproto.NewListener(l, trusted)
+//
+// Only the headers from the trusted sources are parsed, the streams of the
+// others are left untouched. The header is optional, the conns without one
+// report their own addresses.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultHeaderTimeout is the max time to wait for the header.
+const DefaultHeaderTimeout = 10 * time.Second
+
+var (
+	sigV1 = []byte("PROXY ")
+	sigV2 = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+const maxV1Len = 107 // including the CRLF
+
+// ParseCIDRs parses a comma separated list of CIDRs, the bare IPs are taken
+// as single hosts.
+func ParseCIDRs(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("proxyproto: %w", err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// Wrap wraps l with a Listener trusting the comma separated CIDRs, l is
+// returned as is if there is none.
+func Wrap(l net.Listener, cidrs string) (net.Listener, error) {
+	trusted, err := ParseCIDRs(cidrs)
+	if err != nil || len(trusted) == 0 {
+		return l, err
+	}
+	return NewListener(l, trusted), nil
+}
+
+// Listener wraps the conns accepted from the trusted sources to parse their
+// headers.
+type Listener struct {
+	net.Listener
+	trusted []*net.IPNet
+	// HeaderTimeout is the max time to wait for the first bytes of the
+	// trusted conns, whose reads fail after it, DefaultHeaderTimeout if not
+	// positive.
+	HeaderTimeout time.Duration
+}
+
+// NewListener returns a listener trusting the sources in the networks.
+func NewListener(l net.Listener, trusted []*net.IPNet) *Listener {
+	return &Listener{
+		Listener:      l,
+		trusted:       trusted,
+		HeaderTimeout: DefaultHeaderTimeout,
+	}
+}
+
+// Accept returns the next conn, the header is read on the first Read,
+// RemoteAddr or LocalAddr, so that the slow clients do not block Accept.
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.trust(c.RemoteAddr()) {
+		return c, nil
+	}
+	timeout := l.HeaderTimeout
+	if timeout <= 0 {
+		timeout = DefaultHeaderTimeout
+	}
+	return &Conn{
+		Conn:    c,
+		r:       bufio.NewReader(c),
+		timeout: timeout,
+	}, nil
+}
+
+func (l *Listener) trust(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, n := range l.trusted {
+		if n.Contains(tcp.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn is a conn from a trusted source, which may start with a header.
+type Conn struct {
+	net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+	once    sync.Once
+	src     net.Addr
+	dst     net.Addr
+	err     error
+
+	mu           sync.Mutex
+	readDeadline time.Time // set by the callers
+}
+
+// header reads the header once.
+func (c *Conn) header() error {
+	c.once.Do(func() {
+		deadline := time.Now().Add(c.timeout)
+		c.mu.Lock()
+		if d := c.readDeadline; !d.IsZero() && d.Before(deadline) {
+			deadline = d
+		}
+		c.mu.Unlock()
+		c.Conn.SetReadDeadline(deadline)
+		c.src, c.dst, c.err = readHeader(c.r)
+		c.mu.Lock()
+		c.Conn.SetReadDeadline(c.readDeadline)
+		c.mu.Unlock()
+	})
+	return c.err
+}
+
+// Read reads the stream following the header.
+func (c *Conn) Read(b []byte) (int, error) {
+	if err := c.header(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(b)
+}
+
+// RemoteAddr returns the client address in the header, or the address of
+// the conn if there is none.
+func (c *Conn) RemoteAddr() net.Addr {
+	if c.header() == nil && c.src != nil {
+		return c.src
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the destination address in the header, or the address
+// of the conn if there is none.
+func (c *Conn) LocalAddr() net.Addr {
+	if c.header() == nil && c.dst != nil {
+		return c.dst
+	}
+	return c.Conn.LocalAddr()
+}
+
+// ProxyAddr returns the address of the load balancer, which is the remote
+// address of the conn.
+func (c *Conn) ProxyAddr() net.Addr {
+	return c.Conn.RemoteAddr()
+}
+
+// SetDeadline .
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+// SetReadDeadline .
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return c.Conn.SetReadDeadline(t)
+}
+
+// CloseRead stops the reads, the pending one returns an error.
+func (c *Conn) CloseRead() error {
+	if cr, ok := c.Conn.(interface{ CloseRead() error }); ok {
+		return cr.CloseRead()
+	}
+	return c.SetReadDeadline(time.Unix(1, 0))
+}
+
+// CloseWrite closes the write side of the conn.
+func (c *Conn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
+
+// readHeader reads the header if the stream starts with one. The signatures
+// are matched byte by byte, so that the streams without header which are
+// shorter than the signatures are not blocked on.
+func readHeader(r *bufio.Reader) (src, dst net.Addr, err error) {
+	v1, v2 := true, true
+	for i := 0; v1 || v2; i++ {
+		b, err := r.Peek(i + 1)
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return nil, nil, fmt.Errorf("proxyproto: read header error: %w", err)
+		}
+		if err != nil { // no header, the reads get the error
+			return nil, nil, nil
+		}
+		v1 = v1 && b[i] == sigV1[i]
+		v2 = v2 && b[i] == sigV2[i]
+		switch {
+		case v1 && i == len(sigV1)-1:
+			return readV1(r)
+		case v2 && i == len(sigV2)-1:
+			return readV2(r)
+		}
+	}
+	return nil, nil, nil
+}
+
+// readV1 reads a header like "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n".
+func readV1(r *bufio.Reader) (src, dst net.Addr, err error) {
+	var line []byte
+	for len(line) <= maxV1Len {
+		var b byte
+		if b, err = r.ReadByte(); err != nil {
+			return nil, nil, fmt.Errorf("proxyproto: read v1 header error: %w", err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, fmt.Errorf("proxyproto: invalid v1 header %q", line)
+	}
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("proxyproto: invalid v1 header %q", line)
+	}
+	if src, err = tcpAddr(fields[2], fields[4]); err != nil {
+		return nil, nil, err
+	}
+	if dst, err = tcpAddr(fields[3], fields[5]); err != nil {
+		return nil, nil, err
+	}
+	return src, dst, nil
+}
+
+func tcpAddr(host, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	p, err := strconv.ParseUint(port, 10, 16)
+	if ip == nil || err != nil {
+		return nil, fmt.Errorf("proxyproto: invalid address %s:%s", host, port)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+// readV2 reads a binary header, the TLVs are skipped.
+func readV2(r *bufio.Reader) (src, dst net.Addr, err error) {
+	var header [16]byte
+	if _, err = io.ReadFull(r, header[:]); err != nil {
+		return nil, nil, fmt.Errorf("proxyproto: read v2 header error: %w", err)
+	}
+	if header[12]>>4 != 2 {
+		return nil, nil, fmt.Errorf("proxyproto: invalid v2 version %d", header[12]>>4)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return nil, nil, fmt.Errorf("proxyproto: read v2 header error: %w", err)
+	}
+	switch cmd := header[12] & 0xf; cmd {
+	case 0: // LOCAL, e.g. health checks of the balancer
+		return nil, nil, nil
+	case 1: // PROXY
+	default:
+		return nil, nil, fmt.Errorf("proxyproto: invalid v2 command %d", cmd)
+	}
+	var n int
+	switch family := header[13] >> 4; family {
+	case 1: // AF_INET
+		n = net.IPv4len
+	case 2: // AF_INET6
+		n = net.IPv6len
+	default: // AF_UNSPEC or AF_UNIX
+		return nil, nil, nil
+	}
+	if len(payload) < 2*n+4 {
+		return nil, nil, errors.New("proxyproto: v2 addresses are truncated")
+	}
+	src = &net.TCPAddr{
+		IP:   net.IP(append([]byte(nil), payload[:n]...)),
+		Port: int(binary.BigEndian.Uint16(payload[2*n:])),
+	}
+	dst = &net.TCPAddr{
+		IP:   net.IP(append([]byte(nil), payload[n:2*n]...)),
+		Port: int(binary.BigEndian.Uint16(payload[2*n+2:])),
+	}
+	return src, dst, nil
+}
@@ -0,0 +1,132 @@
+package proxyproto
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func v2Header(cmd byte, src, dst *net.TCPAddr) []byte {
+	h := append([]byte(nil), sigV2...)
+	payload := make([]byte, 16)
+	copy(payload, src.IP.To4())
+	copy(payload[4:], dst.IP.To4())
+	binary.BigEndian.PutUint16(payload[8:], uint16(src.Port))
+	binary.BigEndian.PutUint16(payload[10:], uint16(dst.Port))
+	copy(payload[12:], []byte{0x04, 0x00, 0x01, 0xff}) // a NOOP TLV
+	h = append(h, 0x20|cmd, 0x11, 0, byte(len(payload)))
+	return append(h, payload...)
+}
+
+func TestListener(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("192.0.2.1").To4(), Port: 56324}
+	dst := &net.TCPAddr{IP: net.ParseIP("192.0.2.2").To4(), Port: 443}
+	for _, tt := range []struct {
+		name    string
+		trusted string
+		header  string
+		remote  string // empty means the address of the dialer
+	}{
+		{"v1", "127.0.0.0/8", "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n", "192.0.2.1:56324"},
+		{"v1 tcp6", "127.0.0.1", "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n", "[2001:db8::1]:56324"},
+		{"v1 unknown", "127.0.0.0/8", "PROXY UNKNOWN\r\n", ""},
+		{"v2", "127.0.0.0/8", string(v2Header(1, src, dst)), "192.0.2.1:56324"},
+		{"v2 local", "127.0.0.0/8", string(v2Header(0, src, dst)), ""},
+		{"none", "127.0.0.0/8", "", ""},
+		{"untrusted", "10.0.0.0/8", "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n", ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			if l, err = Wrap(l, tt.trusted); err != nil {
+				t.Fatal(err)
+			}
+			c, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			c.Write([]byte(tt.header + "hello"))
+
+			sc, err := l.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sc.Close()
+			sc.SetReadDeadline(time.Now().Add(time.Second))
+			want := "hello"
+			if tt.name == "untrusted" {
+				want = tt.header + want
+			}
+			got := make([]byte, len(want))
+			if _, err = io.ReadFull(sc, got); err != nil || string(got) != want {
+				t.Errorf("Read() = %q, %v, want %q", got, err, want)
+			}
+			remote := tt.remote
+			if remote == "" {
+				remote = c.LocalAddr().String()
+			}
+			if got := sc.RemoteAddr().String(); got != remote {
+				t.Errorf("RemoteAddr() = %s, want %s", got, remote)
+			}
+		})
+	}
+}
+
+func TestShortStream(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	trusted, _ := ParseCIDRs("127.0.0.1")
+	pl := NewListener(l, trusted)
+	pl.HeaderTimeout = 50 * time.Millisecond
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte("PR")) // a prefix of the v1 signature
+
+	sc, err := pl.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+	if _, err = sc.Read(make([]byte, 2)); err == nil {
+		t.Error("Read() of a stalled header error = nil")
+	}
+
+	// a short stream without header is read as is
+	c2, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2.Write([]byte("pr"))
+	c2.(*net.TCPConn).CloseWrite()
+	defer c2.Close()
+	sc2, err := pl.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc2.Close()
+	if b, err := io.ReadAll(sc2); err != nil || string(b) != "pr" {
+		t.Errorf("ReadAll() = %q, %v, want pr", b, err)
+	}
+}
+
+func TestParseCIDRs(t *testing.T) {
+	nets, err := ParseCIDRs(" 10.0.0.0/8, 192.0.2.1 ,::1,")
+	if err != nil || len(nets) != 3 {
+		t.Fatalf("ParseCIDRs() = %v, %v", nets, err)
+	}
+	if _, err = ParseCIDRs("10.0.0.0/33"); err == nil {
+		t.Error("ParseCIDRs() of an invalid CIDR error = nil")
+	}
+}
@@ -3,6 +3,7 @@ package xcron
 import (
 	"context"
 	"errors"
+	"fmt"
 	"sync"
 
 	"github.com/robfig/cron/v3"
@@ -10,10 +11,15 @@ import (
 	"github.com/xsuners/mo/naming"
 	"github.com/xsuners/mo/net/description"
 	"github.com/xsuners/mo/net/leader_checker"
+	"github.com/xsuners/mo/sync/event"
 	"go.uber.org/zap"
 )
 
 type Options struct {
+	// Specs overrides the cron specs of the methods, keyed by service/method,
+	// e.g. "pkg.Service/Method:*/5 * * * * *".
+	Specs map[string]string `ini-name:"specs" long:"cron-specs" description:"cron specs keyed by service/method"`
+
 	unaryInt       description.UnaryServerInterceptor
 	chainUnaryInts []description.UnaryServerInterceptor
 	lc             leader_checker.Checker
@@ -39,6 +45,17 @@ func ChainUnaryInterceptor(interceptors ...description.UnaryServerInterceptor) O
 	}
 }
 
+// Spec overrides the cron spec of the method, which is in form of
+// service/method.
+func Spec(method, spec string) Option {
+	return func(o *Options) {
+		if o.Specs == nil {
+			o.Specs = make(map[string]string)
+		}
+		o.Specs[method] = spec
+	}
+}
+
 func LC(lc leader_checker.Checker) Option {
 	return func(o *Options) {
 		o.lc = lc
@@ -51,8 +68,15 @@ type Server struct {
 	opts     *Options
 	mu       sync.Mutex
 	services map[string]*description.ServiceInfo
+	ready    *event.Event
+	entries  []cron.EntryID // guarded by mu
 }
 
+const defaultSpec = "*/10 * * * * *"
+
+// parser is the parser of cron.WithSeconds.
+var parser = cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
 // New .
 func New(opt ...Option) (description.Server, func()) {
 	opts := defaultOptions
@@ -63,8 +87,9 @@ func New(opt ...Option) (description.Server, func()) {
 		opts:     &opts,
 		cron:     cron.New(cron.WithSeconds()),
 		services: make(map[string]*description.ServiceInfo),
+		ready:    event.NewEvent(),
 	}
-	chainUnaryServerInterceptors(s)
+	s.opts.unaryInt = description.ChainUnaryServer(append([]description.UnaryServerInterceptor{s.opts.unaryInt}, s.opts.chainUnaryInts...)...)
 	return s, func() {
 		log.Info("xhttp is closing...")
 		<-s.cron.Stop().Done()
@@ -72,36 +97,6 @@ func New(opt ...Option) (description.Server, func()) {
 	}
 }
 
-func chainUnaryServerInterceptors(s *Server) {
-	interceptors := s.opts.chainUnaryInts
-	if s.opts.unaryInt != nil {
-		interceptors = append([]description.UnaryServerInterceptor{s.opts.unaryInt}, s.opts.chainUnaryInts...)
-	}
-
-	var chainedInt description.UnaryServerInterceptor
-	if len(interceptors) == 0 {
-		chainedInt = nil
-	} else if len(interceptors) == 1 {
-		chainedInt = interceptors[0]
-	} else {
-		chainedInt = func(ctx context.Context, req interface{}, info *description.UnaryServerInfo, handler description.UnaryHandler) (interface{}, error) {
-			return interceptors[0](ctx, req, info, getChainUnaryHandler(interceptors, 0, info, handler))
-		}
-	}
-
-	s.opts.unaryInt = chainedInt
-}
-
-func getChainUnaryHandler(interceptors []description.UnaryServerInterceptor, curr int, info *description.UnaryServerInfo, finalHandler description.UnaryHandler) description.UnaryHandler {
-	if curr == len(interceptors)-1 {
-		return finalHandler
-	}
-
-	return func(ctx context.Context, req interface{}) (interface{}, error) {
-		return interceptors[curr+1](ctx, req, info, getChainUnaryHandler(interceptors, curr+1, info, finalHandler))
-	}
-}
-
 // Register .
 func (s *Server) Register(ss interface{}, sds ...*description.ServiceDesc) {
 	s.mu.Lock()
@@ -116,38 +111,146 @@ func (s *Server) Register(ss interface{}, sds ...*description.ServiceDesc) {
 
 // Serve .
 func (s *Server) Serve() (err error) {
-	for _, desc := range s.services {
+	s.mu.Lock()
+	err = s.schedule(s.opts.Specs)
+	s.mu.Unlock()
+	if err != nil {
+		return
+	}
+	s.cron.Start()
+	s.ready.Fire()
+	return
+}
+
+// Reload replaces the cron specs with opts.Specs unless it is empty, the
+// methods which are not in opts.Specs fall back to the specs of the service
+// descriptions. The old specs are kept if any of the new ones is invalid.
+func (s *Server) Reload(opts Options) error {
+	commit, _, err := s.PrepareReload(opts)
+	if err != nil {
+		return err
+	}
+	commit()
+	return nil
+}
+
+// PrepareReload parses opts.Specs without scheduling them, commit schedules
+// them as Reload does and abort discards them.
+func (s *Server) PrepareReload(opts Options) (commit, abort func(), err error) {
+	if len(opts.Specs) == 0 {
+		return func() {}, func() {}, nil
+	}
+	s.mu.Lock()
+	jobs, err := s.plan(opts.Specs)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, nil, err
+	}
+	commit = func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.install(jobs)
+		s.opts.Specs = opts.Specs
+	}
+	return commit, func() {}, nil
+}
+
+type job struct {
+	name  string
+	sched cron.Schedule
+	run   func()
+}
+
+// schedule replaces the scheduled jobs with the given specs, s.mu must be held.
+func (s *Server) schedule(specs map[string]string) error {
+	jobs, err := s.plan(specs)
+	if err != nil {
+		return err
+	}
+	s.install(jobs)
+	return nil
+}
+
+// plan parses the specs of all methods into jobs, s.mu must be held.
+func (s *Server) plan(specs map[string]string) ([]job, error) {
+	var jobs []job
+	for svcname, desc := range s.services {
 		for name, m := range desc.Methods() {
-			if len(m.Cron) < 1 {
-				m.Cron = "*/10 * * * * *"
+			spec := m.Cron
+			if sp, ok := specs[svcname+"/"+name]; ok {
+				spec = sp
+			}
+			if len(spec) < 1 {
+				spec = defaultSpec
 			}
 			if m.CheckLeader && s.opts.lc == nil {
-				return errors.New("no leader checker supplied")
+				return nil, errors.New("no leader checker supplied")
+			}
+			sched, err := parser.Parse(spec)
+			if err != nil {
+				return nil, fmt.Errorf("xcron: parse spec of %s/%s error: %w", svcname, name, err)
 			}
-			svc := desc.Service()
-			s.cron.AddFunc(m.Cron, func() {
-				ctx := context.TODO()
-				if m.CheckLeader && !s.opts.lc.IsLeader() {
-					log.Infosc(ctx, "not leader")
-					return
-				}
-				df := func(v interface{}) error {
-					// req, ok := v.(proto.Message)
-					// if !ok {
-					// 	return fmt.Errorf("in type %T is not proto.Message", v)
-					// }
-					// return proto.Unmarshal(in.Data, req)
-					return nil
-				}
-				_, err := m.Handler(svc, ctx, df, s.opts.unaryInt)
-				if err != nil {
-					log.Errorsc(ctx, name, zap.Error(err))
-				}
+			jobs = append(jobs, job{
+				name:  name,
+				sched: sched,
+				run:   s.run(desc.Service(), name, m),
 			})
 		}
 	}
-	s.cron.Start()
-	return
+	return jobs, nil
+}
+
+// install replaces the scheduled jobs with jobs, s.mu must be held.
+func (s *Server) install(jobs []job) {
+	for _, id := range s.entries {
+		s.cron.Remove(id)
+	}
+	s.entries = s.entries[:0]
+	for _, j := range jobs {
+		s.entries = append(s.entries, s.cron.Schedule(j.sched, cron.FuncJob(j.run)))
+	}
+}
+
+func (s *Server) run(svc interface{}, name string, m *description.MethodDesc) func() {
+	return func() {
+		ctx := context.TODO()
+		if m.CheckLeader && !s.opts.lc.IsLeader() {
+			log.Infosc(ctx, "not leader")
+			return
+		}
+		df := func(v interface{}) error {
+			// req, ok := v.(proto.Message)
+			// if !ok {
+			// 	return fmt.Errorf("in type %T is not proto.Message", v)
+			// }
+			// return proto.Unmarshal(in.Data, req)
+			return nil
+		}
+		_, err := m.Handler(svc, ctx, df, s.opts.unaryInt)
+		if err != nil {
+			log.Errorsc(ctx, name, zap.Error(err))
+		}
+	}
+}
+
+// Services .
+func (s *Server) Services() map[string]*description.ServiceInfo {
+	return s.services
+}
+
+// Ready .
+func (s *Server) Ready() <-chan struct{} {
+	return s.ready.Done()
+}
+
+// Drain stops the scheduler and waits for the running jobs.
+func (s *Server) Drain(ctx context.Context) error {
+	select {
+	case <-s.cron.Stop().Done():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func (s *Server) Naming(nm naming.Naming) error {
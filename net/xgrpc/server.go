@@ -12,6 +12,7 @@ import (
 	"github.com/xsuners/mo/naming"
 	"github.com/xsuners/mo/net/description"
 	"github.com/xsuners/mo/net/encoding/json"
+	"github.com/xsuners/mo/sync/event"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/encoding"
 	"google.golang.org/grpc/health/grpc_health_v1"
@@ -72,6 +73,7 @@ type Server struct {
 	mu       sync.Mutex
 	services map[string]*description.ServiceInfo // origin
 	checked  bool
+	ready    *event.Event
 }
 
 // New .
@@ -79,6 +81,7 @@ func New(opt ...Option) (description.Server, func()) {
 	s := &Server{
 		opts:     defaultOptions,
 		services: make(map[string]*description.ServiceInfo),
+		ready:    event.NewEvent(),
 		// conf: c,
 	}
 	for _, o := range opt {
@@ -104,10 +107,42 @@ func (s *Server) Serve() error {
 	if err != nil {
 		return err
 	}
+	s.mu.Lock()
 	s.opts.Port = lis.Addr().(*net.TCPAddr).Port
+	s.mu.Unlock()
+	s.ready.Fire()
 	return s.Server.Serve(lis)
 }
 
+// Port .
+func (s *Server) Port() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.opts.Port
+}
+
+// Ready .
+func (s *Server) Ready() <-chan struct{} {
+	return s.ready.Done()
+}
+
+// Drain stops the server gracefully, it forces the server to stop if
+// pending RPCs are not finished before ctx is done.
+func (s *Server) Drain(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.Server.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.Server.Stop()
+		return ctx.Err()
+	}
+}
+
 func (s *Server) Naming(nm naming.Naming) error {
 	services := make(map[string]struct{})
 	for name := range s.services {
@@ -175,6 +210,9 @@ func convert(in *description.ServiceDesc) (out *grpc.ServiceDesc) {
 		out.Methods = append(out.Methods, grpc.MethodDesc{
 			MethodName: m.MethodName,
 			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				if interceptor == nil {
+					return h(srv, ctx, dec, nil)
+				}
 				return h(srv, ctx, dec, func(ctx context.Context, req interface{}, info *description.UnaryServerInfo, handler description.UnaryHandler) (resp interface{}, err error) {
 					return interceptor(ctx, req, &grpc.UnaryServerInfo{
 						Server:     info.Server,
@@ -147,9 +147,9 @@ func getChainUnaryInvoker(interceptors []description.UnaryClientInterceptor, cur
 	}
 }
 
-// Close .
+// Close closes the idle connections of the client.
 func (c *Client) Close() {
-	// pub.conn.Close()
+	c.CloseIdleConnections()
 }
 
 // Invoke .
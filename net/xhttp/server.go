@@ -8,13 +8,18 @@ import (
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/gin-gonic/gin"
 	"github.com/xsuners/mo/log"
 	"github.com/xsuners/mo/misc/ip"
 	"github.com/xsuners/mo/misc/uhttp"
 	"github.com/xsuners/mo/naming"
+	"github.com/xsuners/mo/net/connection"
 	"github.com/xsuners/mo/net/description"
+	"github.com/xsuners/mo/net/proxyproto"
+	"github.com/xsuners/mo/sync/event"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
@@ -33,11 +38,19 @@ type Options struct {
 	unaryInt              description.UnaryServerInterceptor
 	chainUnaryInts        []description.UnaryServerInterceptor
 	// ip                    string
-	Port int
+	Port        int    `ini-name:"port" long:"http-port" description:"http port"`
+	CorsOrigins string `ini-name:"corsOrigins" long:"http-cors-origins" description:"http cors allowed origins, comma separated"`
+	CorsHeaders string `ini-name:"corsHeaders" long:"http-cors-headers" description:"http cors allowed headers"`
+	CorsMethods string `ini-name:"corsMethods" long:"http-cors-methods" description:"http cors allowed methods"`
+
+	ProxyProtocol string `ini-name:"proxyProtocol" long:"http-proxy-protocol" description:"http trusted CIDRs of the load balancers sending PROXY protocol headers, comma separated, empty disables it"`
 }
 
 var defaultOptions = Options{
-	Port: 8000,
+	Port:        8000,
+	CorsOrigins: uhttp.DefaultCorsConfig.Origins,
+	CorsHeaders: uhttp.DefaultCorsConfig.Headers,
+	CorsMethods: uhttp.DefaultCorsConfig.Methods,
 }
 
 // Option sets server options.
@@ -121,6 +134,35 @@ func Port(port int) Option {
 	}
 }
 
+// CorsOrigins sets the comma separated allowed origins, "*" allows any origin.
+func CorsOrigins(origins string) Option {
+	return func(o *Options) {
+		o.CorsOrigins = origins
+	}
+}
+
+// CorsHeaders .
+func CorsHeaders(headers string) Option {
+	return func(o *Options) {
+		o.CorsHeaders = headers
+	}
+}
+
+// CorsMethods .
+func CorsMethods(methods string) Option {
+	return func(o *Options) {
+		o.CorsMethods = methods
+	}
+}
+
+// ProxyProtocol parses the PROXY protocol headers sent by the load balancers
+// in the CIDRs, so that the requests report the addresses of the clients.
+func ProxyProtocol(cidrs ...string) Option {
+	return func(o *Options) {
+		o.ProxyProtocol = strings.Join(cidrs, ",")
+	}
+}
+
 // Server .
 type Server struct {
 	*gin.Engine
@@ -128,6 +170,10 @@ type Server struct {
 	opts     *Options
 	mu       sync.Mutex
 	services map[string]*description.ServiceInfo
+	handlers map[string]http.Handler // path -> handler
+	hs       *http.Server
+	ready    *event.Event
+	cors     atomic.Value // uhttp.CorsConfig
 }
 
 // New .
@@ -140,45 +186,24 @@ func New(opt ...Option) (description.Server, func()) {
 		opts:     &opts,
 		Engine:   gin.Default(),
 		services: make(map[string]*description.ServiceInfo),
-	}
-	chainUnaryServerInterceptors(s)
-	return s, func() {
-		log.Info("xhttp is closing...")
-		log.Info("xhttp is closed.")
-	}
-}
-
-// chainUnaryServerInterceptors chains all unary server interceptors into one.
-func chainUnaryServerInterceptors(s *Server) {
+		handlers: make(map[string]http.Handler),
+		ready:    event.NewEvent(),
+	}
+	s.hs = &http.Server{}
+	s.cors.Store(uhttp.CorsConfig{
+		Origins: opts.CorsOrigins,
+		Headers: opts.CorsHeaders,
+		Methods: opts.CorsMethods,
+	})
 	// Prepend opts.unaryInt to the chaining interceptors if it exists, since unaryInt will
 	// be executed before any other chained interceptors.
-	interceptors := s.opts.chainUnaryInts
-	if s.opts.unaryInt != nil {
-		interceptors = append([]description.UnaryServerInterceptor{s.opts.unaryInt}, s.opts.chainUnaryInts...)
-	}
-
-	var chainedInt description.UnaryServerInterceptor
-	if len(interceptors) == 0 {
-		chainedInt = nil
-	} else if len(interceptors) == 1 {
-		chainedInt = interceptors[0]
-	} else {
-		chainedInt = func(ctx context.Context, req interface{}, info *description.UnaryServerInfo, handler description.UnaryHandler) (interface{}, error) {
-			return interceptors[0](ctx, req, info, getChainUnaryHandler(interceptors, 0, info, handler))
+	s.opts.unaryInt = description.ChainUnaryServer(append([]description.UnaryServerInterceptor{s.opts.unaryInt}, s.opts.chainUnaryInts...)...)
+	return s, func() {
+		log.Info("xhttp is closing...")
+		if err := s.hs.Close(); err != nil {
+			log.Errorw("xhttp close error", "err", err)
 		}
-	}
-
-	s.opts.unaryInt = chainedInt
-}
-
-// getChainUnaryHandler recursively generate the chained UnaryHandler
-func getChainUnaryHandler(interceptors []description.UnaryServerInterceptor, curr int, info *description.UnaryServerInfo, finalHandler description.UnaryHandler) description.UnaryHandler {
-	if curr == len(interceptors)-1 {
-		return finalHandler
-	}
-
-	return func(ctx context.Context, req interface{}) (interface{}, error) {
-		return interceptors[curr+1](ctx, req, info, getChainUnaryHandler(interceptors, curr+1, info, finalHandler))
+		log.Info("xhttp is closed.")
 	}
 }
 
@@ -194,11 +219,63 @@ func (s *Server) Register(ss interface{}, sds ...*description.ServiceDesc) {
 	}
 }
 
+// Handle serves the GET requests of path by h, e.g. a xws server mounted on
+// s, it must be called before Serve.
+func (s *Server) Handle(path string, h http.Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[path] = h
+}
+
 func (s *Server) Check(c *gin.Context) {}
 
+func (s *Server) corsConfig() uhttp.CorsConfig {
+	return s.cors.Load().(uhttp.CorsConfig)
+}
+
+// Options returns the options of the server, including the reloaded ones.
+func (s *Server) Options() Options {
+	s.mu.Lock()
+	opts := *s.opts
+	s.mu.Unlock()
+	cc := s.corsConfig()
+	opts.CorsOrigins, opts.CorsHeaders, opts.CorsMethods = cc.Origins, cc.Headers, cc.Methods
+	return opts
+}
+
+// Reload applies the reloadable options, the cors settings, the empty values
+// are ignored.
+func (s *Server) Reload(opts Options) error {
+	commit, _, err := s.PrepareReload(opts)
+	if err != nil {
+		return err
+	}
+	commit()
+	return nil
+}
+
+// PrepareReload checks opts without applying them, commit applies them as
+// Reload does and abort discards them.
+func (s *Server) PrepareReload(opts Options) (commit, abort func(), err error) {
+	commit = func() {
+		cc := s.corsConfig()
+		if opts.CorsOrigins != "" {
+			cc.Origins = opts.CorsOrigins
+		}
+		if opts.CorsHeaders != "" {
+			cc.Headers = opts.CorsHeaders
+		}
+		if opts.CorsMethods != "" {
+			cc.Methods = opts.CorsMethods
+		}
+		s.cors.Store(cc)
+	}
+	return commit, func() {}, nil
+}
+
 // Serve .
 func (s *Server) Serve() (err error) {
-	s.Engine.Use(uhttp.Cors())
+	s.Engine.Use(uhttp.CorsFunc(s.corsConfig))
 	s.Use(s.opts.middlewares...)
 
 	if s.opts.pre != nil {
@@ -229,6 +306,10 @@ func (s *Server) Serve() (err error) {
 		s.POST("/pay/:service/:method", s.opts.payer)
 	}
 
+	for path, h := range s.handlers {
+		s.GET(path, gin.WrapH(h))
+	}
+
 	for sname, service := range s.services {
 		for mname, m := range service.Methods() {
 			s.POST("/"+sname+"/"+mname, s.wrap(service.Service(), m.Handler))
@@ -242,16 +323,47 @@ func (s *Server) Serve() (err error) {
 	if err != nil {
 		return err
 	}
+	if lis, err = proxyproto.Wrap(lis, s.opts.ProxyProtocol); err != nil {
+		lis.Close()
+		return err
+	}
+	s.mu.Lock()
 	s.opts.Port = lis.Addr().(*net.TCPAddr).Port
-	err = s.RunListener(lis)
+	s.mu.Unlock()
+	s.hs.Handler = s.Engine.Handler()
+	s.ready.Fire()
+	log.Infof("xhttp listening and serving on %s", lis.Addr().String())
+	err = s.hs.Serve(lis)
 
 	// err = s.Run(fmt.Sprintf(":%d", s.opts.Port))
-	if err != nil {
-		return
+	if err == http.ErrServerClosed {
+		err = nil
 	}
 	return
 }
 
+// Services .
+func (s *Server) Services() map[string]*description.ServiceInfo {
+	return s.services
+}
+
+// Port .
+func (s *Server) Port() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.opts.Port
+}
+
+// Ready .
+func (s *Server) Ready() <-chan struct{} {
+	return s.ready.Done()
+}
+
+// Drain shuts down the server without interrupting any active requests.
+func (s *Server) Drain(ctx context.Context) error {
+	return s.hs.Shutdown(ctx)
+}
+
 // func response(c *gin.Context, code int, message string, data interface{}) {
 // 	out := map[string]interface{}{
 // 		"code":    code,
@@ -276,9 +388,10 @@ func (s *Server) wrap(svc interface{}, handler interface{}) gin.HandlerFunc {
 			}
 			return nil
 		}
+		md := metadata.Pairs(connection.RemoteAddrKey, c.Request.RemoteAddr)
 		o := f.Call([]reflect.Value{
 			reflect.ValueOf(svc),
-			reflect.ValueOf(c.Request.Context()),
+			reflect.ValueOf(metadata.NewIncomingContext(c.Request.Context(), md)),
 			reflect.ValueOf(dec),
 			reflect.ValueOf(s.opts.unaryInt)}) // 调用handler
 		if !o[1].IsNil() { // err != nil
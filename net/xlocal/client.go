@@ -0,0 +1,185 @@
+package xlocal
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xsuners/mo/net/description"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// dialOptions configure a ClientConn.
+type dialOptions struct {
+	unaryInt       description.UnaryClientInterceptor
+	chainUnaryInts []description.UnaryClientInterceptor
+}
+
+// DialOption configures how we set up the connection.
+type DialOption func(*dialOptions)
+
+// WithUnaryInterceptor returns a DialOption that specifies the interceptor for
+// unary RPCs.
+func WithUnaryInterceptor(f description.UnaryClientInterceptor) DialOption {
+	return func(o *dialOptions) {
+		o.unaryInt = f
+	}
+}
+
+// WithChainUnaryInterceptor returns a DialOption that specifies the chained
+// interceptor for unary RPCs. The first interceptor will be the outer most,
+// while the last interceptor will be the inner most wrapper around the real call.
+// All interceptors added by this method will be chained, and the interceptor
+// defined by WithUnaryInterceptor will always be prepended to the chain.
+func WithChainUnaryInterceptor(interceptors ...description.UnaryClientInterceptor) DialOption {
+	return func(o *dialOptions) {
+		o.chainUnaryInts = append(o.chainUnaryInts, interceptors...)
+	}
+}
+
+// ClientConn calls the services of a local server. The requests and replies
+// are cloned rather than shared, as if they were sent over the network.
+type ClientConn struct {
+	opts dialOptions
+	mu   sync.RWMutex
+	s    *Server
+}
+
+var _ description.ClientConnInterface = (*ClientConn)(nil)
+
+// NewClientConn returns a client conn which is not bound to any server yet,
+// use Attach to bind it.
+func NewClientConn(opt ...DialOption) *ClientConn {
+	cc := &ClientConn{}
+	for _, o := range opt {
+		o(&cc.opts)
+	}
+	cc.opts.unaryInt = description.ChainUnaryClient(append([]description.UnaryClientInterceptor{cc.opts.unaryInt}, cc.opts.chainUnaryInts...)...)
+	return cc
+}
+
+// Dial returns a client conn of the local server s.
+func Dial(s description.Server, opt ...DialOption) (*ClientConn, error) {
+	ls, ok := s.(*Server)
+	if !ok {
+		return nil, fmt.Errorf("xlocal: dial error: server type (%T) not match", s)
+	}
+	cc := NewClientConn(opt...)
+	cc.bind(ls)
+	return cc, nil
+}
+
+func (cc *ClientConn) bind(s *Server) {
+	cc.mu.Lock()
+	cc.s = s
+	cc.mu.Unlock()
+}
+
+func (cc *ClientConn) server() *Server {
+	cc.mu.RLock()
+	defer cc.mu.RUnlock()
+	return cc.s
+}
+
+// Invoke .
+func (cc *ClientConn) Invoke(ctx context.Context, method string, args interface{}, reply interface{}, opts ...description.CallOption) error {
+	if cc.opts.unaryInt != nil {
+		return cc.opts.unaryInt(ctx, method, args, reply, cc, invoke, opts...)
+	}
+	return invoke(ctx, method, args, reply, cc, opts...)
+}
+
+func invoke(ctx context.Context, sm string, args interface{}, reply interface{}, c description.UnaryClient, opts ...description.CallOption) error {
+	cc, ok := c.(*ClientConn)
+	if !ok {
+		return fmt.Errorf("xlocal: invoke error: cc type (%T) not match", c)
+	}
+	s := cc.server()
+	if s == nil {
+		return status.Error(codes.Unavailable, "xlocal: client conn is not attached to any server")
+	}
+	if s.quit.HasFired() {
+		return status.Error(codes.Unavailable, "xlocal: server is closed")
+	}
+
+	if sm != "" && sm[0] == '/' {
+		sm = sm[1:]
+	}
+	pos := strings.LastIndex(sm, "/")
+	if pos == -1 {
+		return status.Errorf(codes.Unimplemented, "xlocal: invalid method (%s)", sm)
+	}
+	srv, md, ok := s.method(sm[:pos], sm[pos+1:])
+	if !ok {
+		return status.Errorf(codes.Unimplemented, "xlocal: unknown method (%s)", sm)
+	}
+
+	in, ok := args.(proto.Message)
+	if !ok {
+		return fmt.Errorf("xlocal: args type (%T) is not proto.Message", args)
+	}
+	out, ok := reply.(proto.Message)
+	if !ok {
+		return fmt.Errorf("xlocal: reply type (%T) is not proto.Message", reply)
+	}
+
+	atomic.AddInt64(&s.inflight, 1)
+	defer atomic.AddInt64(&s.inflight, -1)
+
+	sctx := context.Context(detached{ctx})
+	if omd, ok := metadata.FromOutgoingContext(ctx); ok {
+		sctx = metadata.NewIncomingContext(sctx, omd.Copy())
+	}
+	df := func(v interface{}) error {
+		req, ok := v.(proto.Message)
+		if !ok {
+			return fmt.Errorf("xlocal: in type %T is not proto.Message", v)
+		}
+		if req.ProtoReflect().Descriptor().FullName() != in.ProtoReflect().Descriptor().FullName() {
+			return fmt.Errorf("xlocal: in type %T not match args type %T", v, args)
+		}
+		proto.Merge(req, in)
+		return nil
+	}
+	res, err := md.Handler(srv.Service(), sctx, df, s.opts.unaryInt)
+	if err != nil {
+		return err
+	}
+	rm, ok := res.(proto.Message)
+	if !ok {
+		return fmt.Errorf("xlocal: out message (%T) not proto.Message", res)
+	}
+	if rm.ProtoReflect().Descriptor().FullName() != out.ProtoReflect().Descriptor().FullName() {
+		return fmt.Errorf("xlocal: out type %T not match reply type %T", res, reply)
+	}
+	proto.Reset(out)
+	proto.Merge(out, rm)
+	return nil
+}
+
+// NewStream begins a streaming RPC.
+func (cc *ClientConn) NewStream(ctx context.Context, desc *description.StreamDesc, method string, opts ...description.CallOption) (description.ClientStream, error) {
+	return nil, status.Error(codes.Unimplemented, "xlocal: stream is not supported")
+}
+
+// Close .
+func (cc *ClientConn) Close() {}
+
+// detached keeps the deadline and cancellation of the caller's context but
+// none of its values, so the handler sees what it would see over a network.
+type detached struct {
+	parent context.Context
+}
+
+func (d detached) Deadline() (time.Time, bool) { return d.parent.Deadline() }
+func (d detached) Done() <-chan struct{}       { return d.parent.Done() }
+func (d detached) Err() error                  { return d.parent.Err() }
+func (d detached) Value(key interface{}) interface{} {
+	return nil
+}
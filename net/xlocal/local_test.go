@@ -0,0 +1,118 @@
+package xlocal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/xsuners/mo/net/description"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type echoServer interface {
+	Echo(ctx context.Context, in *wrapperspb.StringValue) (*wrapperspb.StringValue, error)
+}
+
+type echo struct{}
+
+func (echo) Echo(ctx context.Context, in *wrapperspb.StringValue) (*wrapperspb.StringValue, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	out := &wrapperspb.StringValue{Value: in.Value}
+	if v := md.Get("suffix"); len(v) > 0 {
+		out.Value += v[0]
+	}
+	in.Value = "changed by handler"
+	return out, nil
+}
+
+func echoHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor description.UnaryServerInterceptor) (interface{}, error) {
+	in := new(wrapperspb.StringValue)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(echoServer).Echo(ctx, in)
+	}
+	info := &description.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/test.Echo/Echo",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(echoServer).Echo(ctx, req.(*wrapperspb.StringValue))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var echoDesc = description.ServiceDesc{
+	ServiceName: "test.Echo",
+	HandlerType: (*echoServer)(nil),
+	Methods: []description.MethodDesc{
+		{
+			MethodName: "Echo",
+			Handler:    echoHandler,
+		},
+	},
+}
+
+func TestInvoke(t *testing.T) {
+	var intercepted string
+	cc := NewClientConn()
+	s, cf := New(Attach(cc), UnaryInterceptor(func(ctx context.Context, req interface{}, info *description.UnaryServerInfo, handler description.UnaryHandler) (interface{}, error) {
+		intercepted = info.FullMethod
+		return handler(ctx, req)
+	}))
+	defer cf()
+	s.Register(echo{}, &echoDesc)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "suffix", "!")
+	in := &wrapperspb.StringValue{Value: "hello"}
+	out := new(wrapperspb.StringValue)
+	if err := cc.Invoke(ctx, "/test.Echo/Echo", in, out); err != nil {
+		t.Fatalf("Invoke() error = %v", err)
+	}
+	if out.Value != "hello!" {
+		t.Errorf("out = %q, want %q", out.Value, "hello!")
+	}
+	if in.Value != "hello" {
+		t.Errorf("in is shared with the handler, in = %q", in.Value)
+	}
+	if intercepted != "/test.Echo/Echo" {
+		t.Errorf("interceptor got %q, want %q", intercepted, "/test.Echo/Echo")
+	}
+
+	err := cc.Invoke(ctx, "/test.Echo/Missing", in, out)
+	if status.Code(err) != codes.Unimplemented {
+		t.Errorf("Invoke() unknown method error = %v, want Unimplemented", err)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	s, cf := New()
+	defer cf()
+	s.Register(echo{}, &echoDesc)
+	cc, err := Dial(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.(description.Drainer).Drain(ctx); err != nil {
+		t.Fatalf("Drain() error = %v", err)
+	}
+	err = cc.Invoke(context.Background(), "/test.Echo/Echo", &wrapperspb.StringValue{}, new(wrapperspb.StringValue))
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("Invoke() after drain error = %v, want Unavailable", err)
+	}
+}
+
+func TestUnattached(t *testing.T) {
+	cc := NewClientConn()
+	err := cc.Invoke(context.Background(), "/test.Echo/Echo", &wrapperspb.StringValue{}, new(wrapperspb.StringValue))
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("Invoke() error = %v, want Unavailable", err)
+	}
+}
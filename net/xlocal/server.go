@@ -0,0 +1,165 @@
+package xlocal
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xsuners/mo/log"
+	"github.com/xsuners/mo/naming"
+	"github.com/xsuners/mo/net/description"
+	"github.com/xsuners/mo/sync/event"
+)
+
+type Options struct {
+	unaryInt       description.UnaryServerInterceptor
+	chainUnaryInts []description.UnaryServerInterceptor
+	conns          []*ClientConn
+}
+
+var defaultOptions = Options{}
+
+// Option sets server options.
+type Option func(*Options)
+
+// UnaryInterceptor returns a Option that sets the UnaryServerInterceptor for the
+// server. Only one unary interceptor can be installed. The construction of multiple
+// interceptors (e.g., chaining) can be implemented at the caller.
+func UnaryInterceptor(i description.UnaryServerInterceptor) Option {
+	return func(o *Options) {
+		if o.unaryInt != nil {
+			panic("The unary server interceptor was already set and may not be reset.")
+		}
+		o.unaryInt = i
+	}
+}
+
+// ChainUnaryInterceptor returns a Option that specifies the chained interceptor
+// for unary RPCs. The first interceptor will be the outer most,
+// while the last interceptor will be the inner most wrapper around the real call.
+// All unary interceptors added by this method will be chained.
+func ChainUnaryInterceptor(interceptors ...description.UnaryServerInterceptor) Option {
+	return func(o *Options) {
+		o.chainUnaryInts = append(o.chainUnaryInts, interceptors...)
+	}
+}
+
+// Attach returns a Option that attaches the client conns to the server, calls
+// on the conns are dispatched to the server once it is created. It is useful
+// when the server is created by others, e.g. as a server of mo.App.
+func Attach(ccs ...*ClientConn) Option {
+	return func(o *Options) {
+		o.conns = append(o.conns, ccs...)
+	}
+}
+
+// Server dispatches calls to the registered services in process.
+type Server struct {
+	opts     Options
+	mu       sync.Mutex
+	services map[string]*description.ServiceInfo
+	ready    *event.Event
+	quit     *event.Event
+	inflight int64 // numbers of calls in process
+}
+
+// New .
+func New(opt ...Option) (description.Server, func()) {
+	opts := defaultOptions
+	for _, o := range opt {
+		o(&opts)
+	}
+	s := &Server{
+		opts:     opts,
+		services: make(map[string]*description.ServiceInfo),
+		ready:    event.NewEvent(),
+		quit:     event.NewEvent(),
+	}
+	// Prepend opts.unaryInt to the chaining interceptors if it exists, since unaryInt will
+	// be executed before any other chained interceptors.
+	s.opts.unaryInt = description.ChainUnaryServer(append([]description.UnaryServerInterceptor{s.opts.unaryInt}, s.opts.chainUnaryInts...)...)
+	for _, cc := range s.opts.conns {
+		cc.bind(s)
+	}
+	return s, func() {
+		log.Info("xlocal is closing...")
+		s.quit.Fire()
+		log.Info("xlocal is closed.")
+	}
+}
+
+var _ description.ServiceRegistrar = (*Server)(nil)
+
+// RegisterService .
+func (s *Server) RegisterService(sd *description.ServiceDesc, ss interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := description.Register(&s.services, sd, ss)
+	if err != nil {
+		log.Fatalw("xlocal register service error", "err", err)
+	}
+}
+
+// Register .
+func (s *Server) Register(ss interface{}, sds ...*description.ServiceDesc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sd := range sds {
+		err := description.Register(&s.services, sd, ss)
+		if err != nil {
+			log.Fatalw("xlocal register service error", "err", err)
+		}
+	}
+}
+
+// Serve marks the server ready, calls are served without it as well.
+func (s *Server) Serve() error {
+	s.ready.Fire()
+	return nil
+}
+
+// Naming does nothing, a local server can not be reached out of process.
+func (s *Server) Naming(nm naming.Naming) error {
+	return nil
+}
+
+// Ready .
+func (s *Server) Ready() <-chan struct{} {
+	return s.ready.Done()
+}
+
+// Services .
+func (s *Server) Services() map[string]*description.ServiceInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.services
+}
+
+// Drain refuses new calls and waits for the calls in process to be done.
+func (s *Server) Drain(ctx context.Context) error {
+	s.quit.Fire()
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for atomic.LoadInt64(&s.inflight) > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+const drainPollInterval = 10 * time.Millisecond
+
+func (s *Server) method(service, method string) (*description.ServiceInfo, *description.MethodDesc, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	srv, ok := s.services[service]
+	if !ok {
+		return nil, nil, false
+	}
+	md, ok := srv.Method(method)
+	return srv, md, ok
+}
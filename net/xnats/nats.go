@@ -2,7 +2,10 @@ package xnats
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/url"
+	"strings"
 	"sync"
 	"time"
 
@@ -11,6 +14,7 @@ import (
 	"github.com/xsuners/mo/naming"
 	"github.com/xsuners/mo/net/description"
 	"github.com/xsuners/mo/net/message"
+	"github.com/xsuners/mo/sync/event"
 	"go.uber.org/zap"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/protobuf/proto"
@@ -20,6 +24,9 @@ type Options struct {
 	// Queue       string `ini-name:"queue" long:"nats-queue" description:"nats queue"`
 	URLs        string `ini-name:"urls" long:"nats-urls" description:"nats urls"`
 	Credentials string `ini-name:"credentials" long:"nats-credentials" description:"nats credentials"`
+	// Subjects overrides the subjects of the methods, keyed by service/method,
+	// the input type of the method is subscribed by default.
+	Subjects map[string]string `ini-name:"subjects" long:"nats-subjects" description:"nats subjects keyed by service/method"`
 
 	// queue          string
 	unaryInt       description.UnaryServerInterceptor
@@ -31,6 +38,8 @@ var defaultOptions = Options{
 	URLs: nats.DefaultURL,
 }
 
+const drainPollInterval = 10 * time.Millisecond
+
 // A Option sets options such as credentials, codec and keepalive parameters, etc.
 type Option interface {
 	apply(*Options)
@@ -94,6 +103,17 @@ func Credentials(credentials string) Option {
 	})
 }
 
+// Subject overrides the subject of the method, which is in form of
+// service/method.
+func Subject(method, subject string) Option {
+	return newFuncOption(func(o *Options) {
+		if o.Subjects == nil {
+			o.Subjects = make(map[string]string)
+		}
+		o.Subjects[method] = subject
+	})
+}
+
 // URLS .
 func URLS(urls string) Option {
 	return newFuncOption(func(o *Options) {
@@ -101,13 +121,33 @@ func URLS(urls string) Option {
 	})
 }
 
+// ValidateURLs reports whether urls, separated by commas, are missing or
+// malformed. The urls without scheme are taken as nats ones, as nats.Connect
+// does.
+func ValidateURLs(urls string) error {
+	if strings.TrimSpace(urls) == "" {
+		return errors.New("xnats: urls is missing")
+	}
+	for _, u := range strings.Split(urls, ",") {
+		u = strings.TrimSpace(u)
+		if !strings.Contains(u, "://") {
+			u = "nats://" + u
+		}
+		if pu, err := url.Parse(u); err != nil || pu.Host == "" {
+			return fmt.Errorf("xnats: url %q is invalid", u)
+		}
+	}
+	return nil
+}
+
 // Server .
 type Server struct {
 	opts     Options
 	mu       sync.Mutex
 	conn     *nats.Conn
 	services map[string]*description.ServiceInfo
-	subs     []*nats.Subscription
+	subs     map[string]*nats.Subscription // keyed by service/method, guarded by mu
+	ready    *event.Event
 }
 
 // New .
@@ -119,12 +159,19 @@ func New(opt ...Option) (description.Server, func(), error) {
 	s := &Server{
 		opts:     opts,
 		services: make(map[string]*description.ServiceInfo),
+		subs:     make(map[string]*nats.Subscription),
+		ready:    event.NewEvent(),
 	}
-	chainUnaryServerInterceptors(s)
+	// Prepend opts.unaryInt to the chaining interceptors if it exists, since unaryInt will
+	// be executed before any other chained interceptors.
+	s.opts.unaryInt = description.ChainUnaryServer(append([]description.UnaryServerInterceptor{s.opts.unaryInt}, s.opts.chainUnaryInts...)...)
 	s.opts.nopts = setupConnOptions(s.opts.nopts)
 	if s.opts.Credentials != "" {
 		s.opts.nopts = append(s.opts.nopts, nats.UserCredentials(s.opts.Credentials))
 	}
+	if err := ValidateURLs(s.opts.URLs); err != nil {
+		return nil, nil, err
+	}
 	log.Infos(s.opts.URLs)
 	conn, err := nats.Connect(s.opts.URLs, s.opts.nopts...)
 	if err != nil {
@@ -138,40 +185,6 @@ func New(opt ...Option) (description.Server, func(), error) {
 	}, nil
 }
 
-// chainUnaryServerInterceptors chains all unary server interceptors into one.
-func chainUnaryServerInterceptors(s *Server) {
-	// Prepend opts.unaryInt to the chaining interceptors if it exists, since unaryInt will
-	// be executed before any other chained interceptors.
-	interceptors := s.opts.chainUnaryInts
-	if s.opts.unaryInt != nil {
-		interceptors = append([]description.UnaryServerInterceptor{s.opts.unaryInt}, s.opts.chainUnaryInts...)
-	}
-
-	var chainedInt description.UnaryServerInterceptor
-	if len(interceptors) == 0 {
-		chainedInt = nil
-	} else if len(interceptors) == 1 {
-		chainedInt = interceptors[0]
-	} else {
-		chainedInt = func(ctx context.Context, req interface{}, info *description.UnaryServerInfo, handler description.UnaryHandler) (interface{}, error) {
-			return interceptors[0](ctx, req, info, getChainUnaryHandler(interceptors, 0, info, handler))
-		}
-	}
-
-	s.opts.unaryInt = chainedInt
-}
-
-// getChainUnaryHandler recursively generate the chained UnaryHandler
-func getChainUnaryHandler(interceptors []description.UnaryServerInterceptor, curr int, info *description.UnaryServerInfo, finalHandler description.UnaryHandler) description.UnaryHandler {
-	if curr == len(interceptors)-1 {
-		return finalHandler
-	}
-
-	return func(ctx context.Context, req interface{}) (interface{}, error) {
-		return interceptors[curr+1](ctx, req, info, getChainUnaryHandler(interceptors, curr+1, info, finalHandler))
-	}
-}
-
 func setupConnOptions(opts []nats.Option) []nats.Option {
 	totalWait := 10 * time.Minute
 	reconnectDelay := time.Second
@@ -222,27 +235,204 @@ func (c *Server) Register(ss interface{}, sds ...*description.ServiceDesc) {
 
 // Serve .
 func (c *Server) Serve() (err error) {
+	c.mu.Lock()
+	err = c.subscribe(c.opts.Subjects)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// c.conn.Flush()
+	if err := c.conn.LastError(); err != nil {
+		return err
+	}
+	c.ready.Fire()
+	return nil
+}
+
+// Reload resubscribes the methods whose subjects are changed by opts.Subjects
+// unless it is empty, the methods which are not in opts.Subjects fall back to
+// their input types. The old subscriptions are kept if any of the new ones
+// fails.
+func (c *Server) Reload(opts Options) error {
+	commit, _, err := c.PrepareReload(opts)
+	if err != nil {
+		return err
+	}
+	commit()
+	return nil
+}
+
+// PrepareReload subscribes the changed subjects of opts, commit drains the
+// replaced subscriptions as Reload does and abort unsubscribes the new ones.
+func (c *Server) PrepareReload(opts Options) (commit, abort func(), err error) {
+	if len(opts.Subjects) == 0 {
+		return func() {}, func() {}, nil
+	}
+	c.mu.Lock()
+	subs, err := c.prepare(opts.Subjects)
+	c.mu.Unlock()
+	if err != nil {
+		return nil, nil, err
+	}
+	commit = func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.swap(subs)
+		c.opts.Subjects = opts.Subjects
+	}
+	abort = func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.discard(subs)
+	}
+	return commit, abort, nil
+}
+
+// subscribe subscribes the subjects of all methods, the subscriptions of
+// unchanged subjects are kept and the replaced ones are drained. c.mu must be
+// held.
+func (c *Server) subscribe(subjects map[string]string) error {
+	subs, err := c.prepare(subjects)
+	if err != nil {
+		return err
+	}
+	c.swap(subs)
+	return nil
+}
+
+// prepare subscribes the subjects of all methods which are not subscribed
+// yet, and returns the subscriptions of all methods. c.mu must be held.
+func (c *Server) prepare(subjects map[string]string) (_ map[string]*nats.Subscription, err error) {
+	subs := make(map[string]*nats.Subscription)
+	defer func() {
+		if err != nil {
+			c.discard(subs)
+		}
+	}()
 	for svcname, info := range c.services {
-		for _, method := range info.Methods() {
+		for name, method := range info.Methods() {
+			key := svcname + "/" + name
+			subj := method.Input
+			if s, ok := subjects[key]; ok {
+				subj = s
+			}
+			if old, ok := c.subs[key]; ok && old.Subject == subj && old.IsValid() {
+				subs[key] = old
+				continue
+			}
 			var sub *nats.Subscription
 			if method.Broadcast { // 支持广播监听
-				sub, err = c.conn.Subscribe(method.Input, c.wrap(info.Service(), method.Handler))
+				sub, err = c.conn.Subscribe(subj, c.wrap(info.Service(), method.Handler))
 			} else {
-				sub, err = c.conn.QueueSubscribe(method.Input, svcname, c.wrap(info.Service(), method.Handler))
+				sub, err = c.conn.QueueSubscribe(subj, svcname, c.wrap(info.Service(), method.Handler))
 			}
 			if err != nil {
-				return err
+				return nil, err
 			}
-			c.subs = append(c.subs, sub)
+			subs[key] = sub
 			log.Infos("xnats:serve", zap.String("queue", sub.Queue), zap.String("subj", sub.Subject))
 		}
 	}
+	return subs, nil
+}
+
+// discard unsubscribes the subscriptions of subs made by prepare. c.mu must
+// be held.
+func (c *Server) discard(subs map[string]*nats.Subscription) {
+	for key, sub := range subs {
+		if c.subs[key] != sub {
+			sub.Unsubscribe()
+		}
+	}
+}
 
-	// c.conn.Flush()
-	if err := c.conn.LastError(); err != nil {
-		return err
+// swap replaces the subscriptions with subs, draining the replaced ones. c.mu
+// must be held.
+func (c *Server) swap(subs map[string]*nats.Subscription) {
+	for key, old := range c.subs {
+		if subs[key] == old {
+			continue
+		}
+		if err := old.Drain(); err != nil {
+			log.Errors("xnats:drain sub", zap.Error(err))
+		}
+	}
+	c.subs = subs
+}
+
+// Subject returns the subject of the method of the service, which is the one
+// in Subjects if any, the input type of the method otherwise.
+func (c *Server) Subject(service, method string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if sub, ok := c.subs[service+"/"+method]; ok {
+		return sub.Subject
+	}
+	if s, ok := c.opts.Subjects[service+"/"+method]; ok {
+		return s
+	}
+	if info, ok := c.services[service]; ok {
+		if m, ok := info.Method(method); ok {
+			return m.Input
+		}
+	}
+	return ""
+}
+
+func (c *Server) subscriptions() []*nats.Subscription {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	subs := make([]*nats.Subscription, 0, len(c.subs))
+	for _, sub := range c.subs {
+		subs = append(subs, sub)
+	}
+	return subs
+}
+
+// Services .
+func (c *Server) Services() map[string]*description.ServiceInfo {
+	return c.services
+}
+
+// Conn returns the nats conn of the server, which can be shared by the
+// publishers and subscribers of the same process.
+func (c *Server) Conn() *nats.Conn {
+	return c.conn
+}
+
+// Ready .
+func (c *Server) Ready() <-chan struct{} {
+	return c.ready.Done()
+}
+
+// Drain unsubscribes all subjects after the pending messages are processed.
+func (c *Server) Drain(ctx context.Context) error {
+	subs := c.subscriptions()
+	for _, sub := range subs {
+		if err := sub.Drain(); err != nil {
+			log.Errors("xnats:drain sub", zap.Error(err))
+		}
+	}
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		var draining bool
+		for _, sub := range subs {
+			if sub.IsValid() {
+				draining = true
+				break
+			}
+		}
+		if !draining {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
 	}
-	return nil
 }
 
 // TODO 提取公共代码
@@ -379,7 +569,10 @@ func (s *Server) Naming(nm naming.Naming) error {
 
 // Stop .
 func (c *Server) Stop() {
-	for _, sub := range c.subs {
+	for _, sub := range c.subscriptions() {
+		if !sub.IsValid() { // drained already
+			continue
+		}
 		err := sub.Drain()
 		if err != nil {
 			log.Errors("xnats:stop sub drain", zap.Error(err))
@@ -1,23 +1,31 @@
 package xtcp
 
 import (
-	"bytes"
 	"context"
-	"encoding/binary"
+	"crypto/tls"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/xsuners/mo/log"
+	"github.com/xsuners/mo/naming"
 	"github.com/xsuners/mo/net/connection"
 	"github.com/xsuners/mo/net/description"
 	"github.com/xsuners/mo/net/encoding"
 	"github.com/xsuners/mo/net/encoding/proto"
 	"github.com/xsuners/mo/net/message"
+	"github.com/xsuners/mo/sync/event"
 	"github.com/xsuners/mo/timer"
 	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	pbproto "google.golang.org/protobuf/proto"
 )
 
@@ -43,6 +51,23 @@ type dialOptions struct {
 	// chainStreamInts []StreamClientInterceptor
 	// nopts []nats.Option
 	bufferSize int // size of buffered channel
+	timeout    time.Duration
+
+	tlsCfg        *tls.Config
+	clientID      string
+	clientVersion string
+	token         string
+
+	maxFrameSize      int
+	compressors       []string
+	compressThreshold int
+
+	// used by Client only
+	resolver     naming.Resolver
+	backoffBase  time.Duration
+	backoffMax   time.Duration
+	auth         func(ctx context.Context, cc *ClientConn) error
+	waitForReady bool
 }
 
 // DialOption configures how we set up the connection.
@@ -112,8 +137,8 @@ func CloseOption(cb func(connection.Conn)) DialOption {
 	})
 }
 
-// MessageOption returns a DialOption that will set callback to call when new
-// client connected.
+// MessageOption returns a DialOption that will set callback to call when a
+// message which is not the reply of any pending call, e.g. a push, is received.
 func MessageOption(cb func([]byte) error) DialOption {
 	return newFuncDialOption(func(o *dialOptions) {
 		o.onmessage = cb
@@ -128,6 +153,100 @@ func CustomCodec(codec encoding.Codec) DialOption {
 	})
 }
 
+// WithTimeout returns a DialOption that bounds the dial and handshake, and the
+// calls without deadline.
+func WithTimeout(d time.Duration) DialOption {
+	return newFuncDialOption(func(o *dialOptions) {
+		o.timeout = d
+	})
+}
+
+// WithCompressors returns a DialOption that offers the compressors to the
+// server in the handshake, in order of preference.
+func WithCompressors(names ...string) DialOption {
+	return newFuncDialOption(func(o *dialOptions) {
+		o.compressors = names
+	})
+}
+
+// WithTLS returns a DialOption that dials the server with TLS, the
+// certificates of config are sent if the server asks for client
+// certificates.
+func WithTLS(config *tls.Config) DialOption {
+	return newFuncDialOption(func(o *dialOptions) {
+		o.tlsCfg = config
+	})
+}
+
+// WithClientInfo returns a DialOption that sends the id and the version of
+// the client in the handshake.
+func WithClientInfo(id, version string) DialOption {
+	return newFuncDialOption(func(o *dialOptions) {
+		o.clientID = id
+		o.clientVersion = version
+	})
+}
+
+// WithToken returns a DialOption that sends token in the handshake, it is
+// checked by the HandshakeAuth of the server.
+func WithToken(token string) DialOption {
+	return newFuncDialOption(func(o *dialOptions) {
+		o.token = token
+	})
+}
+
+// WithMaxFrameSize returns a DialOption that sets the max bytes of a frame
+// payload from the server.
+func WithMaxFrameSize(n int) DialOption {
+	return newFuncDialOption(func(o *dialOptions) {
+		o.maxFrameSize = n
+	})
+}
+
+// WithCompressThreshold returns a DialOption that sets the min size of the
+// messages to compress once a compressor is negotiated.
+func WithCompressThreshold(n int) DialOption {
+	return newFuncDialOption(func(o *dialOptions) {
+		o.compressThreshold = n
+	})
+}
+
+// WithResolver returns a DialOption that makes the Client take the target as
+// a service name, e.g. "tcp.user", and dial one of its instances resolved by
+// r on every connect.
+func WithResolver(r naming.Resolver) DialOption {
+	return newFuncDialOption(func(o *dialOptions) {
+		o.resolver = r
+	})
+}
+
+// WithBackoff returns a DialOption that sets the delays between the connects
+// of the Client, the delay doubles from base up to max, with jitter.
+func WithBackoff(base, max time.Duration) DialOption {
+	return newFuncDialOption(func(o *dialOptions) {
+		o.backoffBase = base
+		o.backoffMax = max
+	})
+}
+
+// WithAuth returns a DialOption that sets the function the Client calls on
+// every new conn after the handshake, e.g. to send the auth message again.
+// The conn is dropped if it returns error.
+func WithAuth(auth func(ctx context.Context, cc *ClientConn) error) DialOption {
+	return newFuncDialOption(func(o *dialOptions) {
+		o.auth = auth
+	})
+}
+
+// WithWaitForReady returns a DialOption that makes the calls of the Client
+// issued while disconnected wait for the next conn, bounded by their ctx,
+// instead of failing with codes.Unavailable at once.
+func WithWaitForReady(wait bool) DialOption {
+	return newFuncDialOption(func(o *dialOptions) {
+		o.waitForReady = wait
+	})
+}
+
 // WithCodec .
 func WithCodec(ccname string) DialOption {
 	return newFuncDialOption(func(o *dialOptions) {
@@ -158,25 +277,42 @@ func defaultDialOptions() dialOptions {
 		// resolveNowBackoff: internalbackoff.DefaultExponential.Backoff,
 		// withProxy:         true,
 		bufferSize: 1024,
+		timeout:    10 * time.Second,
+
+		maxFrameSize:      message.MaxBytes,
+		compressThreshold: 1024,
+
+		backoffBase: time.Second,
+		backoffMax:  time.Minute,
 	}
 }
 
-// ClientConn .
+// ClientConn is a client connection to a xtcp server, it calls the services
+// of the server with Invoke, the replies are matched to the calls by the
+// message id.
 type ClientConn struct {
 	id       int64
 	user     connection.User
 	addr     string
 	opts     dialOptions
 	raw      net.Conn
+	fr       *message.FrameReader
+	fw       *message.FrameWriter
 	wg       sync.WaitGroup
-	sendCh   chan []byte
+	sendCh   chan frame
 	timerid  int64
 	updateAt time.Time
-	closed   bool
-	// ctx      context.Context
-	// cancel   context.CancelFunc
+	quit     *event.Event
+	goaway   *event.Event
+	comp     encoding.Compressor // set by handshake
+
+	mu      sync.Mutex
+	seq     int64
+	pending map[string]chan *message.Message
 }
 
+var _ description.ClientConnInterface = (*ClientConn)(nil)
+
 // NewClientConn returns a new client connection which has not started to
 // serve requests yet.
 func NewClientConn(netid int64, c net.Conn, opt ...DialOption) *ClientConn {
@@ -184,51 +320,120 @@ func NewClientConn(netid int64, c net.Conn, opt ...DialOption) *ClientConn {
 	for _, o := range opt {
 		o.apply(&opts)
 	}
-	// TODO intercepter
+	// Prepend opts.unaryInt to the chaining interceptors if it exists, since unaryInt will
+	// be executed before any other chained interceptors.
+	opts.unaryInt = description.ChainUnaryClient(append([]description.UnaryClientInterceptor{opts.unaryInt}, opts.chainUnaryInts...)...)
 	cc := &ClientConn{
 		id:       netid,
 		addr:     c.RemoteAddr().String(),
 		opts:     opts,
 		raw:      c,
+		fr:       message.NewFrameReader(c, opts.maxFrameSize),
+		fw:       message.NewFrameWriter(c),
 		wg:       sync.WaitGroup{},
-		sendCh:   make(chan []byte, opts.bufferSize),
+		sendCh:   make(chan frame, opts.bufferSize),
 		updateAt: time.Now(),
+		quit:     event.NewEvent(),
+		goaway:   event.NewEvent(),
+		pending:  make(map[string]chan *message.Message),
 	}
-	// cc.ctx, cc.cancel = context.WithCancel(context.Background())
 	return cc
 }
 
+// Dial connects to the xtcp server at addr and starts the conn, the codec is
+// selected before it returns.
+func Dial(addr string, opt ...DialOption) (*ClientConn, error) {
+	opts := defaultDialOptions()
+	for _, o := range opt {
+		o.apply(&opts)
+	}
+	var raw net.Conn
+	var err error
+	if opts.tlsCfg != nil {
+		raw, err = tls.DialWithDialer(&net.Dialer{Timeout: opts.timeout}, "tcp", addr, opts.tlsCfg)
+	} else {
+		raw, err = net.DialTimeout("tcp", addr, opts.timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	cc := NewClientConn(connection.GenID(), raw, opt...)
+	raw.SetDeadline(time.Now().Add(opts.timeout))
+	err = cc.handshake()
+	raw.SetDeadline(time.Time{})
+	if err != nil {
+		cc.Close()
+		return nil, err
+	}
+	go cc.serve()
+	return cc, nil
+}
+
+// handshake sends the structured handshake, the servers before it reply in
+// the legacy form, which is accepted as well.
 func (cc *ClientConn) handshake() (err error) {
-	ccn := []byte(cc.opts.codec.Name())
-	buf := new(bytes.Buffer)
-	binary.Write(buf, binary.LittleEndian, int32(len(ccn)))
-	buf.Write(ccn)
-	_, err = cc.raw.Write(buf.Bytes())
+	hs, err := json.Marshal(&Handshake{
+		Version:       HandshakeVersion,
+		Codec:         cc.opts.codec.Name(),
+		Compressors:   cc.opts.compressors,
+		ClientID:      cc.opts.clientID,
+		ClientVersion: cc.opts.clientVersion,
+		Token:         cc.opts.token,
+	})
 	if err != nil {
 		return err
 	}
-	data, err := message.Decode(cc.raw)
+	if err = cc.fw.WriteFrame(hs, false); err != nil {
+		return err
+	}
+	if err = cc.fw.Flush(); err != nil {
+		return err
+	}
+	data, compressed, err := cc.fr.ReadFrame()
+	if err == nil && compressed {
+		err = errCompressor
+	}
 	if err != nil {
 		log.Errors("xtcp: set serializer error", zap.Error(err))
 		return
 	}
-	if !bytes.Equal(data, ccn) {
+	reply, err := parseReply(data)
+	if err != nil {
+		log.Errors("xtcp: handshake error", zap.Error(err))
+		return
+	}
+	if reply.Code != 0 {
+		err = status.Error(codes.Code(reply.Code), reply.Desc)
+		log.Errors("xtcp: handshake refused", zap.Error(err))
+		return
+	}
+	if reply.Codec != cc.opts.codec.Name() {
 		err = errors.New("xtcp: codec not support")
 		log.Errors("xtcp: handshake error", zap.Error(err))
 		return
 	}
+	if reply.Compressor != "" {
+		if cc.comp = encoding.SelectCompressor([]string{reply.Compressor}, cc.opts.compressors); cc.comp == nil {
+			err = fmt.Errorf("xtcp: compressor %s not offered", reply.Compressor)
+			log.Errors("xtcp: handshake error", zap.Error(err))
+			return
+		}
+	}
 	return
 }
 
-// Start .
+// Start selects the codec and serves the conn until it is closed.
 func (cc *ClientConn) Start() {
-	log.Infof("conn start, <%v -> %v>", cc.raw.LocalAddr(), cc.raw.RemoteAddr())
-
 	if err := cc.handshake(); err != nil {
 		log.Errors("xtcp: start error", zap.Error(err))
 		cc.Close()
 		return
 	}
+	cc.serve()
+}
+
+func (cc *ClientConn) serve() {
+	log.Infof("conn start, <%v -> %v>", cc.raw.LocalAddr(), cc.raw.RemoteAddr())
 
 	if onconnect := cc.opts.onconnect; onconnect != nil {
 		onconnect(cc)
@@ -238,14 +443,12 @@ func (cc *ClientConn) Start() {
 	go func() {
 		cc.readLoop()
 		cc.wg.Done()
-		// cc.cancel()
 	}()
 
 	cc.wg.Add(1)
 	go func() {
 		cc.writeLoop()
 		cc.wg.Done()
-		// cc.cancel()
 	}()
 
 	cc.check()
@@ -259,44 +462,59 @@ func (cc *ClientConn) Start() {
 
 // check .
 func (cc *ClientConn) check() {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.quit.HasFired() {
+		return
+	}
 	cc.timerid = timer.RunEvery(time.Minute, func(tid int64) {
-		if cc.closed {
-			// 理论不会及此
+		if cc.quit.HasFired() {
 			timer.Cancel(tid)
-			log.Infos("理论不会及此")
 			return
 		}
-		if time.Since(cc.updateAt) > time.Minute {
+		cc.mu.Lock()
+		updateAt := cc.updateAt
+		cc.mu.Unlock()
+		if time.Since(updateAt) > time.Minute {
 			cc.Close()
 			log.Infos("heartbeat timeout")
 		}
 	})
 }
 
-// Close .
+// Close closes the conn, the pending calls fail with codes.Unavailable.
 func (cc *ClientConn) Close() {
-	if cc.closed {
+	if !cc.quit.Fire() {
 		return
 	}
 	log.Infow("xtcp: conn close start", "loacl", cc.raw.LocalAddr(), "remote", cc.raw.RemoteAddr())
 	defer log.Infow("xtcp: conn close done")
-	cc.closed = true
+	cc.mu.Lock()
 	timer.Cancel(cc.timerid)
-	// cc.cancel()
+	cc.pending = nil
+	cc.mu.Unlock()
 	cc.raw.Close()
-	close(cc.sendCh)
 }
 
-// Write writes a message to the client.
-func (cc *ClientConn) Write(message []byte) error {
-	if cc.closed {
+// Done returns a channel which is closed when the conn is closed.
+func (cc *ClientConn) Done() <-chan struct{} {
+	return cc.quit.Done()
+}
+
+// GoAway returns a channel which is closed when the server sends GOAWAY, the
+// new calls fail with codes.Unavailable since then.
+func (cc *ClientConn) GoAway() <-chan struct{} {
+	return cc.goaway.Done()
+}
+
+// Write writes a message to the server, it is compressed if a compressor is
+// negotiated and it is large enough.
+func (cc *ClientConn) Write(data []byte) error {
+	if cc.quit.HasFired() {
 		return errors.New("conn is closed")
 	}
-	buf := new(bytes.Buffer)
-	binary.Write(buf, binary.LittleEndian, int32(len(message)))
-	buf.Write(message)
 	select {
-	case cc.sendCh <- buf.Bytes():
+	case cc.sendCh <- encodeFrame(cc.comp, cc.opts.compressThreshold, data):
 		return nil
 	default:
 		return errors.New("xtcp: would block")
@@ -312,6 +530,118 @@ func (cc *ClientConn) WriteMessage(message pbproto.Message) (err error) {
 	return cc.Write(data)
 }
 
+// Invoke sends the request to method, which is in form of /service/method,
+// and waits for the reply. The outgoing metadata of ctx is sent as well.
+func (cc *ClientConn) Invoke(ctx context.Context, method string, args interface{}, reply interface{}, opts ...description.CallOption) error {
+	if cc.opts.unaryInt != nil {
+		return cc.opts.unaryInt(ctx, method, args, reply, cc, invoke, opts...)
+	}
+	return invoke(ctx, method, args, reply, cc, opts...)
+}
+
+func invoke(ctx context.Context, sm string, args interface{}, reply interface{}, c description.UnaryClient, opts ...description.CallOption) error {
+	cc, ok := c.(*ClientConn)
+	if !ok {
+		return fmt.Errorf("xtcp: invoke error: cc type (%T) not match", c)
+	}
+	if cc.goaway.HasFired() {
+		return status.Error(codes.Unavailable, "xtcp: server is going away")
+	}
+	if sm != "" && sm[0] == '/' {
+		sm = sm[1:]
+	}
+	pos := strings.LastIndex(sm, "/")
+	if pos == -1 {
+		return status.Errorf(codes.Unimplemented, "xtcp: invalid method (%s)", sm)
+	}
+	data, err := cc.opts.codec.Marshal(args)
+	if err != nil {
+		return status.Errorf(codes.Internal, "xtcp: marshal args error: %v", err)
+	}
+	req := &message.Message{
+		Service: sm[:pos],
+		Method:  sm[pos+1:],
+		Data:    data,
+	}
+	if md, ok := metadata.FromOutgoingContext(ctx); ok {
+		req.Metas = message.EncodeMetadata(md)
+	}
+
+	id, ch, err := cc.register()
+	if err != nil {
+		return err
+	}
+	defer cc.unregister(id)
+	req.Messageid = id
+	if data, err = cc.opts.codec.Marshal(req); err != nil {
+		return status.Errorf(codes.Internal, "xtcp: marshal message error: %v", err)
+	}
+	if err = cc.Write(data); err != nil {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+
+	if _, ok := ctx.Deadline(); !ok && cc.opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cc.opts.timeout)
+		defer cancel()
+	}
+	select {
+	case res := <-ch:
+		if res.Code != 0 {
+			return status.Error(codes.Code(res.Code), res.Desc)
+		}
+		if err = cc.opts.codec.Unmarshal(res.Data, reply); err != nil {
+			return status.Errorf(codes.Internal, "xtcp: unmarshal reply error: %v", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	case <-cc.quit.Done():
+		return status.Error(codes.Unavailable, "xtcp: conn is closed")
+	}
+}
+
+// NewStream is not supported.
+func (cc *ClientConn) NewStream(ctx context.Context, desc *description.StreamDesc, method string, opts ...description.CallOption) (description.ClientStream, error) {
+	return nil, status.Error(codes.Unimplemented, "xtcp: stream is not supported")
+}
+
+// register allocates a message id and the channel its reply is sent to.
+func (cc *ClientConn) register() (string, chan *message.Message, error) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.pending == nil {
+		return "", nil, status.Error(codes.Unavailable, "xtcp: conn is closed")
+	}
+	cc.seq++
+	id := strconv.FormatInt(cc.seq, 10)
+	ch := make(chan *message.Message, 1)
+	cc.pending[id] = ch
+	return id, ch, nil
+}
+
+func (cc *ClientConn) unregister(id string) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	delete(cc.pending, id)
+}
+
+// dispatch sends msg to the pending call of its id, it reports false if
+// there is no such call.
+func (cc *ClientConn) dispatch(msg *message.Message) bool {
+	if msg.Messageid == "" {
+		return false
+	}
+	cc.mu.Lock()
+	ch, ok := cc.pending[msg.Messageid]
+	delete(cc.pending, msg.Messageid)
+	cc.mu.Unlock()
+	if ok {
+		ch <- msg
+	}
+	return ok
+}
+
 // ID .
 func (cc *ClientConn) ID() int64 {
 	return cc.id
@@ -322,6 +652,11 @@ func (cc *ClientConn) Codec() encoding.Codec {
 	return cc.opts.codec
 }
 
+// Compressor returns the compressor negotiated, nil if there is none.
+func (cc *ClientConn) Compressor() encoding.Compressor {
+	return cc.comp
+}
+
 // User .
 func (cc *ClientConn) User() connection.User {
 	return cc.user
@@ -329,7 +664,9 @@ func (cc *ClientConn) User() connection.User {
 
 // Heartbeat .
 func (cc *ClientConn) Heartbeat(ctx context.Context) (err error) {
+	cc.mu.Lock()
 	cc.updateAt = time.Now()
+	cc.mu.Unlock()
 	return
 }
 
@@ -359,104 +696,70 @@ func (cc *ClientConn) LocalAddr() net.Addr {
 
 func (cc *ClientConn) readLoop() {
 	defer func() {
-		// if p := recover(); p != nil {
-		// 	log.Errorf("panics: %v", p)
-		// }
+		cc.fr.Release()
+		cc.Close()
 		log.Debug("xtcp: read loop exited")
 	}()
 
 	for {
-		data, err := message.Decode(cc.raw)
+		data, compressed, err := cc.fr.ReadFrame()
 		if err != nil {
 			if err == io.EOF {
 				log.Infow("xtcp: client conn closed by server side")
 				return
 			}
-			if cc.closed {
-				log.Infos("conn closed")
+			if cc.quit.HasFired() {
+				log.Debugs("xtcp: conn closed")
 				return
 			}
 			log.Errorw("xtcp: client decoding message error", "err", err)
 			return
 		}
-		// TODO
-		// log.Infow("xtcp: client revced message", "message", data)
-		if cc.opts.onmessage != nil {
-			if err = cc.opts.onmessage(data); err != nil {
-				log.Debugs("om message error", zap.Error(err))
-			}
+		cc.Heartbeat(context.Background()) // any inbound frame counts
+		if data, err = decodePayload(cc.comp, data, compressed, cc.fr.Max()); err != nil {
+			log.Errorw("xtcp: client decompress message error", "err", err)
+			return
 		}
-		// select {
-		// case <-cc.ctx.Done(): // connection closed
-		// 	return
-		// default:
-		data, err = message.Decode(cc.raw)
-		if err != nil {
-			if err == io.EOF {
-				log.Infow("xtcp: client conn closed by server side")
-				return
+		msg := &message.Message{}
+		if err = cc.opts.codec.Unmarshal(data, msg); err == nil {
+			if message.IsGoAway(msg) {
+				log.Infow("xtcp: server is going away", "remote", cc.raw.RemoteAddr(), "desc", msg.Desc)
+				cc.goaway.Fire()
+				continue
 			}
-			if cc.closed {
-				log.Debugs("xtcp: conn closed")
-				return
+			if message.IsControl(msg) {
+				if msg.Method == message.MethodPing {
+					if err = cc.WriteMessage(message.Pong()); err != nil {
+						log.Debugs("xtcp: write pong error", zap.Error(err))
+					}
+				}
+				continue
+			}
+			if cc.dispatch(msg) {
+				continue
 			}
-			log.Errorw("xtcp: client decoding message error", "err", err)
-			return
 		}
-		// TODO
-		// log.Infow("xtcp: client revced message", "message", data)
 		if cc.opts.onmessage != nil {
-			if err = cc.opts.onmessage(data); err != nil {
+			// data is the buffer of the frame reader, which the next frame
+			// overwrites
+			if err = cc.opts.onmessage(append([]byte(nil), data...)); err != nil {
 				log.Debugs("om message error", zap.Error(err))
 			}
 		}
-		// }
 	}
 }
 
 func (cc *ClientConn) writeLoop() {
-	defer func() {
-		// 	if p := recover(); p != nil {
-		// 		log.Errorf("panics: %v", p)
-		// 	}
-		// 	// drain all pending messages before exit
-		// OuterFor:
-		// 	for {
-		// 		select {
-		// 		case pkt := <-cc.sendCh:
-		// 			if pkt != nil {
-		// 				if _, err := cc.raw.Write(pkt); err != nil {
-		// 					log.Errorf("error writing data %v", err)
-		// 				}
-		// 			}
-		// 		default:
-		// 			break OuterFor
-		// 		}
-		// 	}
-		log.Debug("xtcp: write loop exited")
-	}()
+	defer log.Debug("xtcp: write loop exited")
 
 	for {
-		// select {
-		// case <-cc.ctx.Done(): // connection closed
-		// 	return
-		// case
-		if cc.closed {
-			log.Debugs("xtcp: conn closed")
+		select {
+		case <-cc.quit.Done():
 			return
-		}
-		pkt := <-cc.sendCh
-		if pkt != nil {
-			// data, err := cc.opts.codec.Encode(pkt)
-			// if err != nil {
-			// 	log.Errors("encode data error", zap.Error(err))
-			// 	continue
-			// }
-			if _, err := cc.raw.Write(pkt); err != nil {
+		case f := <-cc.sendCh:
+			if err := writeFrames(cc.fw, f, cc.sendCh); err != nil {
 				log.Errors("writing data error", zap.Error(err))
-				continue
 			}
 		}
-		// }
 	}
 }
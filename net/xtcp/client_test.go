@@ -0,0 +1,286 @@
+package xtcp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xsuners/mo/net/connection"
+	"github.com/xsuners/mo/net/description"
+	mproto "github.com/xsuners/mo/net/encoding/proto"
+	"github.com/xsuners/mo/net/message"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func startServer(t *testing.T, port int) (*Server, func()) {
+	t.Helper()
+	ds, stop := New(Port(port))
+	s := ds.(*Server)
+	go s.Serve()
+	select {
+	case <-s.Ready():
+	case <-time.After(time.Second):
+		t.Fatal("server is not ready")
+	}
+	return s, stop
+}
+
+func waitState(t *testing.T, ch <-chan State, want State) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case state := <-ch:
+			if state == want {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("state %v is not reached", want)
+		}
+	}
+}
+
+func TestClientReconnect(t *testing.T) {
+	s, stop := startServer(t, 0)
+	port := s.Port()
+
+	var auths int32
+	c := NewClient(fmt.Sprintf("127.0.0.1:%d", port),
+		WithBackoff(10*time.Millisecond, 50*time.Millisecond),
+		WithAuth(func(ctx context.Context, cc *ClientConn) error {
+			atomic.AddInt32(&auths, 1)
+			return nil
+		}),
+	)
+	states := c.Watch()
+	waitState(t, states, Ready)
+	wc := NewClient(fmt.Sprintf("127.0.0.1:%d", port), WithBackoff(10*time.Millisecond, 50*time.Millisecond), WithWaitForReady(true))
+	defer wc.Close()
+	wstates := wc.Watch()
+	waitState(t, wstates, Ready)
+
+	// the server is down until it is started again below, so that the
+	// clients can not reconnect in between
+	stop()
+	waitState(t, states, TransientFailure)
+	waitState(t, wstates, TransientFailure)
+	t.Run("fail fast", func(t *testing.T) {
+		start := time.Now()
+		err := c.Invoke(context.Background(), "/test.Echo/Echo", nil, nil)
+		if status.Code(err) != codes.Unavailable {
+			t.Errorf("Invoke() while disconnected error = %v, want Unavailable", err)
+		}
+		if d := time.Since(start); d > 100*time.Millisecond {
+			t.Errorf("Invoke() while disconnected took %v", d)
+		}
+	})
+	t.Run("wait for ready", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err := wc.Invoke(ctx, "/test.Echo/Echo", nil, nil)
+		if status.Code(err) != codes.DeadlineExceeded {
+			t.Errorf("Invoke() while disconnected error = %v, want DeadlineExceeded", err)
+		}
+	})
+
+	waited := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		waited <- wc.Invoke(ctx, "/test.Echo/Echo", nil, nil)
+	}()
+	_, stop = startServer(t, port)
+	defer stop()
+	waitState(t, states, Ready)
+	switch err := <-waited; status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Canceled:
+		t.Errorf("Invoke() waiting for ready error = %v", err)
+	}
+	if n := atomic.LoadInt32(&auths); n != 2 {
+		t.Errorf("auths = %d, want 2", n)
+	}
+
+	c.Close()
+	waitState(t, states, Shutdown)
+	if _, ok := <-states; ok {
+		t.Error("watch channel is not closed")
+	}
+}
+
+func TestDrainGoAway(t *testing.T) {
+	s, stop := startServer(t, 0)
+	defer stop()
+	cc, err := Dial(fmt.Sprintf("127.0.0.1:%d", s.Port()))
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer cc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err = s.Drain(ctx); err != nil {
+		t.Fatalf("Drain() error = %v", err)
+	}
+	for name, ch := range map[string]<-chan struct{}{"goaway": cc.GoAway(), "close": cc.Done()} {
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Errorf("%s is not received", name)
+		}
+	}
+	err = cc.Invoke(context.Background(), "/test.Echo/Echo", nil, nil)
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("Invoke() after goaway error = %v", err)
+	}
+}
+
+func TestDrainRefusesNewRequests(t *testing.T) {
+	ds, stop := New(Port(0), UnknownServiceHandler(func(ctx context.Context, service, method string, in []byte, interceptor description.UnaryServerInterceptor) (interface{}, error) {
+		time.Sleep(50 * time.Millisecond)
+		return &message.Message{}, nil
+	}))
+	defer stop()
+	s := ds.(*Server)
+	go s.Serve()
+	<-s.Ready()
+	raw, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", s.Port()))
+	if err != nil {
+		t.Fatalf("net.Dial() error = %v", err)
+	}
+	defer raw.Close()
+	raw.Write(message.EncodeFrame([]byte("proto"), false))
+	if _, err = message.Decode(raw); err != nil {
+		t.Fatalf("read handshake reply error = %v", err)
+	}
+
+	// the client keeps sending requests after GOAWAY
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for i := 0; ; i++ {
+			data, _ := (mproto.Codec{}).Marshal(&message.Message{Service: "test.Echo", Method: "Echo", Messageid: fmt.Sprint(i)})
+			if _, err := raw.Write(message.EncodeFrame(data, false)); err != nil {
+				return
+			}
+			select {
+			case <-done:
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+		}
+	}()
+	replies := make(chan codes.Code, 1024)
+	go func() {
+		defer close(replies)
+		for {
+			data, err := message.Decode(raw)
+			if err != nil {
+				return
+			}
+			msg := &message.Message{}
+			if (mproto.Codec{}).Unmarshal(data, msg) == nil && !message.IsControl(msg) {
+				replies <- codes.Code(msg.Code)
+			}
+		}
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err = s.Drain(ctx); err != nil {
+		t.Fatalf("Drain() error = %v", err)
+	}
+	got := map[codes.Code]int{}
+	for code := range replies {
+		got[code]++
+	}
+	if got[codes.OK] == 0 || got[codes.Unavailable] == 0 {
+		t.Errorf("reply codes = %v, want both OK and Unavailable", got)
+	}
+}
+
+func TestKeepalive(t *testing.T) {
+	reasons := make(chan error, 2)
+	ds, stop := New(Port(0),
+		Keepalive(100*time.Millisecond, 0, 100*time.Millisecond),
+		CloseHandler(func(c connection.Conn) {
+			reasons <- c.(*ServerConn).CloseReason()
+		}),
+	)
+	defer stop()
+	s := ds.(*Server)
+	go s.Serve()
+	<-s.Ready()
+	addr := fmt.Sprintf("127.0.0.1:%d", s.Port())
+
+	// a client replying pongs is kept
+	cc, err := Dial(addr)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer cc.Close()
+
+	// a client never replying is closed
+	raw, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("net.Dial() error = %v", err)
+	}
+	defer raw.Close()
+	raw.Write(message.EncodeFrame([]byte("proto"), false))
+
+	select {
+	case reason := <-reasons:
+		if reason != ErrPongTimeout {
+			t.Errorf("close reason = %v, want %v", reason, ErrPongTimeout)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("silent conn is not closed")
+	}
+	select {
+	case <-cc.Done():
+		t.Error("conn replying pongs is closed")
+	default:
+	}
+}
+
+func TestMessageOptionKeepsData(t *testing.T) {
+	ds, stop := New(Port(0), ConnectHandler(func(c connection.Conn) {
+		c.WriteMessage(&message.Message{Service: "push", Desc: "first"})
+		c.WriteMessage(&message.Message{Service: "push", Desc: "second"})
+	}))
+	defer stop()
+	s := ds.(*Server)
+	go s.Serve()
+	<-s.Ready()
+
+	pushes := make(chan []byte, 2)
+	cc, err := Dial(fmt.Sprintf("127.0.0.1:%d", s.Port()), MessageOption(func(data []byte) error {
+		pushes <- data // kept after the callback returns
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer cc.Close()
+
+	var kept [][]byte
+	for i := 0; i < 2; i++ {
+		select {
+		case data := <-pushes:
+			kept = append(kept, data)
+		case <-time.After(time.Second):
+			t.Fatal("push is not received")
+		}
+	}
+	// the first data is intact after the second frame is read
+	for i, want := range []string{"first", "second"} {
+		msg := new(message.Message)
+		if err := (mproto.Codec{}).Unmarshal(kept[i], msg); err != nil || msg.Desc != want {
+			t.Errorf("push %d = %v, %v, want %s", i, msg, err, want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package xtcp
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/xsuners/mo/log"
+	"github.com/xsuners/mo/net/encoding"
+	_ "github.com/xsuners/mo/net/encoding/gzip"   // register gzip compressor
+	_ "github.com/xsuners/mo/net/encoding/snappy" // register snappy compressor
+	"github.com/xsuners/mo/net/message"
+	"go.uber.org/zap"
+)
+
+var errCompressor = errors.New("xtcp: compressed frame without compressor negotiated")
+
+func splitList(s string) (list []string) {
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return
+}
+
+// frame is a payload queued to write.
+type frame struct {
+	data       []byte
+	compressed bool
+}
+
+// encodeFrame returns the frame of data, the payload is compressed by comp
+// if data has threshold bytes at least and compressing makes it smaller.
+func encodeFrame(comp encoding.Compressor, threshold int, data []byte) frame {
+	if comp != nil && len(data) >= threshold {
+		out, err := encoding.Compress(comp, data)
+		if err != nil {
+			log.Errors("xtcp: compress error", zap.Error(err))
+		} else if len(out) < len(data) {
+			return frame{data: out, compressed: true}
+		}
+	}
+	return frame{data: data}
+}
+
+// writeFrames writes f and the frames queued in mc behind it, and flushes
+// them in one write.
+func writeFrames(fw *message.FrameWriter, f frame, mc <-chan frame) error {
+	for {
+		if err := fw.WriteFrame(f.data, f.compressed); err != nil {
+			return err
+		}
+		select {
+		case f = <-mc:
+			continue
+		default:
+		}
+		return fw.Flush()
+	}
+}
+
+// decodePayload returns the payload of a frame decompressed by comp if it is
+// flagged compressed, the decompressed payload has max bytes at most.
+func decodePayload(comp encoding.Compressor, data []byte, compressed bool, max int) ([]byte, error) {
+	if !compressed {
+		return data, nil
+	}
+	if comp == nil {
+		return nil, errCompressor
+	}
+	return encoding.Decompress(comp, data, max)
+}
@@ -1,24 +1,23 @@
 package xtcp
 
 import (
-	"bytes"
 	"context"
-	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"io"
 	"net"
-	"regexp"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/xsuners/mo/log"
 	"github.com/xsuners/mo/net/connection"
 	"github.com/xsuners/mo/net/encoding"
-	"github.com/xsuners/mo/net/encoding/json"
-	"github.com/xsuners/mo/net/encoding/proto"
 	"github.com/xsuners/mo/net/message"
+	"github.com/xsuners/mo/sync/event"
 	"github.com/xsuners/mo/timer"
 	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	pbproto "google.golang.org/protobuf/proto"
@@ -35,34 +34,72 @@ import (
 
 var _ connection.Conn = (*ServerConn)(nil)
 
+// The close reasons of the conns closed by keepalive.
+var (
+	ErrIdleTimeout = errors.New("xtcp: idle timeout")
+	ErrPongTimeout = errors.New("xtcp: pong timeout")
+)
+
+var (
+	// ErrWouldBlock is returned by Write when the message is dropped since
+	// the write queue is full.
+	ErrWouldBlock = errors.New("xtcp: would block")
+	// ErrSlowConsumer is the close reason of the conns closed by
+	// PolicyDisconnect.
+	ErrSlowConsumer = errors.New("xtcp: slow consumer")
+)
+
+// ConnStats is the stats of the write queue of a conn.
+type ConnStats struct {
+	ID       int64
+	Queued   int   // messages waiting to be written
+	Capacity int   // size of the queue
+	Dropped  int64 // messages dropped since the queue is full
+}
+
 // ServerConn represents a server connection to a TCP server, it implments Conn.
 type ServerConn struct {
 	id       int64
 	user     connection.User
 	codec    encoding.Codec
 	server   *Server
-	raw      *net.TCPConn
+	raw      net.Conn // a *net.TCPConn, a *proxyproto.Conn, or a *tls.Conn over them
+	peer     *connection.Peer
+	fr       *message.FrameReader
+	fw       *message.FrameWriter
 	wg       sync.WaitGroup
-	mc       chan []byte
-	closed   bool
-	mcclosed bool
+	mc       chan frame
+	quit     *event.Event
+	activeAt int64 // unix nano of the last inbound frame or heartbeat
+	pingAt   int64 // unix nano of the ping waiting for reply, 0 if none
+	dropped  int64
+	draining int32      // 1 once GOAWAY is sent, the new requests are refused
+	mu       sync.Mutex // guards the fields below
+	comp     encoding.Compressor
+	hs       *Handshake
 	timerid  int64
-	updateAt time.Time
+	reason   error
 }
 
-func newServerConn(id int64, s *Server, c *net.TCPConn) *ServerConn {
+func newServerConn(id int64, s *Server, c net.Conn, bufferSize, maxFrame int) *ServerConn {
 	return &ServerConn{
 		id:       id,
 		server:   s,
 		raw:      c,
+		fr:       message.NewFrameReader(c, maxFrame),
+		fw:       message.NewFrameWriter(c),
 		wg:       sync.WaitGroup{},
-		mc:       make(chan []byte, s.opts.BufferSize),
-		updateAt: time.Now(),
+		mc:       make(chan frame, bufferSize),
+		quit:     event.NewEvent(),
+		activeAt: time.Now().UnixNano(),
 	}
 }
 
 func (sc *ServerConn) handshake() (err error) {
-	data, err := message.Decode(sc.raw)
+	data, compressed, err := sc.fr.ReadFrame()
+	if err == nil && compressed {
+		err = errCompressor
+	}
 	if err != nil {
 		if err == io.EOF {
 			log.Debugs("xtcp: maybe health check")
@@ -71,34 +108,87 @@ func (sc *ServerConn) handshake() (err error) {
 		log.Errors("xtcp: set serializer error", zap.Error(err))
 		return
 	}
-	var selected bool
-	ok, err := regexp.Match("proto", data)
+	hs, err := parseHandshake(data)
 	if err != nil {
-		log.Errors("xtcp: protocol error", zap.Error(err))
-		return
+		log.Warns("xtcp: handshake error", zap.ByteString("data", data), zap.Error(err))
+		return sc.refuse(data, status.Error(codes.InvalidArgument, err.Error()))
 	}
-	if ok {
-		sc.codec = encoding.GetCodec(proto.Name)
-		selected = true
-	} else {
-		ok, err = regexp.Match("json", data)
+	codec := selectCodec(hs)
+	if codec == nil {
+		log.Warns("serializer select errerr", zap.ByteString("data", data))
+		return sc.refuse(data, status.Errorf(codes.Unimplemented, "xtcp: codec %q is not supported", hs.Codec))
+	}
+	sc.server.mu.Lock()
+	allowed := splitList(sc.server.opts.Compressors)
+	sc.server.mu.Unlock()
+	comp := encoding.SelectCompressor(hs.Compressors, allowed)
+	if auth := sc.server.opts.handshakeAuth; auth != nil {
+		user, err := auth(sc.context(), hs)
 		if err != nil {
-			log.Errors("xtcp: protocol error", zap.Error(err))
-			return
+			log.Infos("xtcp: handshake auth error", zap.String("client", hs.ClientID), zap.Error(err))
+			if _, ok := status.FromError(err); !ok {
+				err = status.Error(codes.Unauthenticated, err.Error())
+			}
+			return sc.refuse(data, err)
 		}
-		if ok {
-			selected = true
-			sc.codec = encoding.GetCodec(json.Name)
+		sc.user = user
+	}
+	sc.mu.Lock()
+	sc.hs = hs
+	sc.codec = codec
+	sc.comp = comp
+	sc.mu.Unlock()
+
+	var reply []byte
+	if !structured(data) {
+		if comp == nil {
+			reply = formatLegacy(codec.Name())
+		} else {
+			reply = formatLegacy(codec.Name(), comp.Name())
+		}
+	} else {
+		r := &HandshakeReply{Version: HandshakeVersion, Codec: codec.Name()}
+		if hs.Version < r.Version {
+			r.Version = hs.Version
+		}
+		if comp != nil {
+			r.Compressor = comp.Name()
+		}
+		if reply, err = json.Marshal(r); err != nil {
+			return
 		}
 	}
-	if !selected {
-		log.Warns("serializer select errerr", zap.ByteString("data", data))
-		// TODO
-		sc.raw.Close()
-		return
+	return sc.Write(reply)
+}
+
+// refuse replies err to a structured handshake, and closes the conn. It
+// returns err.
+func (sc *ServerConn) refuse(data []byte, err error) error {
+	defer sc.raw.Close()
+	if !structured(data) {
+		return err
 	}
-	sc.Write([]byte(sc.codec.Name()))
-	return
+	st := status.Convert(err)
+	reply, merr := json.Marshal(&HandshakeReply{
+		Version: HandshakeVersion,
+		Code:    int32(st.Code()),
+		Desc:    st.Message(),
+	})
+	if merr != nil {
+		return err
+	}
+	// the write loop is not started yet
+	if werr := sc.fw.WriteFrame(reply, false); werr == nil {
+		sc.fw.Flush()
+	}
+	return err
+}
+
+// Handshake returns the handshake of the conn, nil before it is done.
+func (sc *ServerConn) Handshake() *Handshake {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.hs
 }
 
 func (sc *ServerConn) start() {
@@ -119,25 +209,95 @@ func (sc *ServerConn) start() {
 	sc.check() // heartbeat check
 
 	sc.wg.Wait()
+	sc.raw.Close()
 	log.Infos("xtcp conn closed done")
 }
 
-// check .
+// closeRead stops the reads of c, the pending one returns an error.
+func closeRead(c net.Conn) error {
+	if cr, ok := c.(interface{ CloseRead() error }); ok {
+		return cr.CloseRead()
+	}
+	return c.SetReadDeadline(time.Unix(1, 0)) // tls conns can not be half closed
+}
+
+// closeWrite closes the write side of c, the whole conn if it can not be half
+// closed.
+func closeWrite(c net.Conn) error {
+	if cw, ok := c.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Close()
+}
+
+// Peer returns the identity of the verified client certificate, nil if the
+// conn is not TLS or the client sent none.
+func (sc *ServerConn) Peer() *connection.Peer {
+	return sc.peer
+}
+
+// context returns the context of the requests of the conn.
+func (sc *ServerConn) context() context.Context {
+	ctx := connection.NewContxet(context.Background(), sc)
+	if sc.peer != nil {
+		ctx = connection.NewPeerContext(ctx, sc.peer)
+	}
+	return ctx
+}
+
+// check closes the conn once it is idle for IdleTimeout, and pings it once
+// it is idle for HeartbeatInterval if PongTimeout is set.
 func (sc *ServerConn) check() {
-	sc.timerid = timer.RunEvery(time.Minute, func(tid int64) {
-		if sc.closed {
-			// 理论不会及此
+	opts := &sc.server.opts
+	if opts.HeartbeatInterval <= 0 {
+		return
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.quit.HasFired() {
+		return
+	}
+	sc.timerid = timer.RunEvery(opts.HeartbeatInterval, func(tid int64) {
+		if sc.quit.HasFired() {
 			timer.Cancel(tid)
-			log.Infos("理论不会及此")
 			return
 		}
-		if time.Since(sc.updateAt) > time.Minute {
-			sc.Close()
-			log.Infos("xtcp heartbeat timeout")
+		idle := time.Since(time.Unix(0, atomic.LoadInt64(&sc.activeAt)))
+		if opts.IdleTimeout > 0 && idle > opts.IdleTimeout {
+			log.Infos("xtcp heartbeat timeout", zap.Int64("conn", sc.id), zap.Duration("idle", idle))
+			sc.closeWith(ErrIdleTimeout)
+			return
+		}
+		if opts.PongTimeout > 0 && idle >= opts.HeartbeatInterval {
+			sc.ping(opts.PongTimeout)
+		}
+	})
+}
+
+// ping sends a ping unless one is waiting for reply, the conn is closed if
+// there is no inbound frame in timeout.
+func (sc *ServerConn) ping(timeout time.Duration) {
+	now := time.Now().UnixNano()
+	if !atomic.CompareAndSwapInt64(&sc.pingAt, 0, now) {
+		return
+	}
+	if err := sc.WriteMessage(message.Ping()); err != nil {
+		log.Warns("xtcp: write ping error", zap.Error(err))
+	}
+	timer.RunAfter(timeout, func(int64) {
+		if atomic.LoadInt64(&sc.activeAt) < now {
+			log.Infos("xtcp pong timeout", zap.Int64("conn", sc.id))
+			sc.closeWith(ErrPongTimeout)
 		}
 	})
 }
 
+// active records an inbound frame or heartbeat.
+func (sc *ServerConn) active() {
+	atomic.StoreInt64(&sc.activeAt, time.Now().UnixNano())
+	atomic.StoreInt64(&sc.pingAt, 0)
+}
+
 // ID .
 func (sc *ServerConn) ID() int64 {
 	return sc.id
@@ -154,7 +314,7 @@ func (sc *ServerConn) User() connection.User {
 
 // Heartbeat .
 func (sc *ServerConn) Heartbeat(ctx context.Context) (err error) {
-	sc.updateAt = time.Now()
+	sc.active()
 	return
 }
 
@@ -169,22 +329,70 @@ func (sc *ServerConn) Auth(ctx context.Context, user connection.User) (err error
 		return
 	}
 	sc.user = user
+	if h := sc.server.opts.hub; h != nil {
+		h.Bind(sc)
+	}
 	return
 }
 
-// Write writes a message to the client.
-func (sc *ServerConn) Write(message []byte) error {
-	if sc.closed {
+// Write writes a message to the client, it is compressed if a compressor is
+// negotiated and it is large enough.
+func (sc *ServerConn) Write(data []byte) error {
+	if sc.quit.HasFired() {
 		return errors.New("conn is closed")
 	}
-	buf := new(bytes.Buffer)
-	binary.Write(buf, binary.LittleEndian, int32(len(message)))
-	buf.Write(message)
+	sc.mu.Lock()
+	comp := sc.comp
+	sc.mu.Unlock()
+	frame := encodeFrame(comp, sc.server.opts.CompressThreshold, data)
 	select {
-	case sc.mc <- buf.Bytes():
+	case sc.mc <- frame:
 		return nil
 	default:
-		return errors.New("xtcp: would block")
+	}
+
+	opts := &sc.server.opts
+	switch opts.WritePolicy {
+	case PolicyDropOldest:
+		for {
+			select {
+			case sc.mc <- frame:
+				return nil
+			default:
+			}
+			select {
+			case <-sc.mc:
+				atomic.AddInt64(&sc.dropped, 1)
+			default:
+			}
+		}
+	case PolicyBlock:
+		t := time.NewTimer(opts.WriteTimeout)
+		defer t.Stop()
+		select {
+		case sc.mc <- frame:
+			return nil
+		case <-sc.quit.Done():
+			return errors.New("conn is closed")
+		case <-t.C:
+		}
+	}
+	dropped := atomic.AddInt64(&sc.dropped, 1)
+	if opts.WritePolicy == PolicyDisconnect && dropped >= int64(opts.MaxDrops) {
+		log.Warns("xtcp: close slow consumer", zap.Int64("conn", sc.id), zap.Int64("dropped", dropped))
+		sc.closeWith(ErrSlowConsumer)
+		sc.raw.Close() // the queued messages would block the close
+	}
+	return ErrWouldBlock
+}
+
+// Stats returns the stats of the write queue.
+func (sc *ServerConn) Stats() ConnStats {
+	return ConnStats{
+		ID:       sc.id,
+		Queued:   len(sc.mc),
+		Capacity: cap(sc.mc),
+		Dropped:  atomic.LoadInt64(&sc.dropped),
 	}
 }
 
@@ -207,80 +415,136 @@ func (sc *ServerConn) LocalAddr() net.Addr {
 	return sc.raw.LocalAddr()
 }
 
-// Close .
+// Close stops reading from the conn, the queued messages are still written
+// before the write side is closed.
 func (sc *ServerConn) Close() {
-	if sc.closed {
+	sc.closeWith(nil)
+}
+
+// CloseReason returns why the conn is closed, e.g. ErrIdleTimeout, io.EOF
+// if the client closed it, or nil if it is closed by Close. It is meant to be
+// called in the CloseHandler.
+func (sc *ServerConn) CloseReason() error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.reason
+}
+
+func (sc *ServerConn) closeWith(reason error) {
+	sc.mu.Lock()
+	if sc.quit.HasFired() {
+		sc.mu.Unlock()
 		return
 	}
-	sc.closed = true
+	sc.reason = reason
+	sc.quit.Fire()
+	sc.mu.Unlock()
+
 	if sc.user != nil {
 		sc.user.Disconnected()
 	}
+	sc.mu.Lock()
 	timer.Cancel(sc.timerid)
-	if len(sc.mc) < 1 {
-		log.Debugs("xtcp mc closed 1")
-		sc.mcclosed = true
-		close(sc.mc)
-	}
-	if err := sc.raw.CloseRead(); err != nil {
+	sc.mu.Unlock()
+	if err := closeRead(sc.raw); err != nil {
 		log.Errors("xtcp conn close read error", zap.Error(err))
 	}
 }
 
+// goAway sends the GOAWAY control message if the codec is selected already,
+// it reports whether the message is queued. The requests read afterwards are
+// replied with Unavailable.
+func (sc *ServerConn) goAway() bool {
+	atomic.StoreInt32(&sc.draining, 1)
+	sc.mu.Lock()
+	codec := sc.codec
+	sc.mu.Unlock()
+	if codec == nil {
+		return false
+	}
+	data, err := codec.Marshal(message.GoAway("xtcp: server is draining"))
+	if err != nil {
+		log.Errors("xtcp: marshal goaway error", zap.Error(err))
+		return false
+	}
+	if err = sc.Write(data); err != nil {
+		log.Warns("xtcp: write goaway error", zap.Error(err))
+		return false
+	}
+	return true
+}
+
+// queued returns the number of messages waiting to be written.
+func (sc *ServerConn) queued() int {
+	return len(sc.mc)
+}
+
 // readLoop .
 func (sc *ServerConn) readLoop() {
-	defer sc.Close()
+	var reason error
+	defer func() {
+		sc.fr.Release()
+		sc.closeWith(reason)
+	}()
 	for {
-		data, err := message.Decode(sc.raw)
+		data, compressed, err := sc.fr.ReadFrame()
 		if err != nil {
 			if err == io.EOF {
 				log.Infos("xtcp read loop closed 1")
+				reason = err
 				return
 			}
-			if sc.closed {
+			if sc.quit.HasFired() {
 				log.Infos("xtcp read loop closed 2")
 				return
 			}
 			log.Errors("xtcp read loop closed 3", zap.Error(err))
+			reason = err
+			return
+		}
+		sc.active()
+		if data, reason = decodePayload(sc.comp, data, compressed, sc.fr.Max()); reason != nil {
+			log.Errors("xtcp: decompress message error", zap.Error(reason))
 			return
 		}
-		message := &message.Message{}
-		if err = sc.codec.Unmarshal(data, message); err != nil {
-			log.Errors("xtcp: unmarshal message error", zap.Error(err))
+		msg := &message.Message{}
+		if reason = sc.codec.Unmarshal(data, msg); reason != nil {
+			log.Errors("xtcp: unmarshal message error", zap.Error(reason))
 			return
 		}
-		sc.process(message)
+		if message.IsControl(msg) { // pong, counted as activity only
+			continue
+		}
+		sc.process(msg)
 	}
 }
 
-// writeLoop .
+// writeLoop writes the queued messages, the ones queued in a row are sent in
+// one write.
 func (sc *ServerConn) writeLoop() {
 	defer func() {
-		if !sc.mcclosed {
-			close(sc.mc)
-			sc.mcclosed = true
-			log.Infos("xtcp mc closed 2")
-		}
-		if err := sc.raw.CloseWrite(); err != nil {
+		if err := closeWrite(sc.raw); err != nil {
 			log.Infos("xtcp conn close write error:", zap.Error(err))
 			return
 		}
 	}()
 	for {
-		m, ok := <-sc.mc
-		if !ok {
-			log.Infos("xtcp write loop closed 1")
-			return
-		}
-		if _, err := sc.raw.Write(m); err != nil {
-			log.Errorf("xtcp error writing data %v", err)
-			continue
-		}
-		if sc.closed {
-			if len(sc.mc) < 1 {
-				log.Infos("xtcp write loop closed 2")
-				return
+		select {
+		case f := <-sc.mc:
+			if err := writeFrames(sc.fw, f, sc.mc); err != nil {
+				log.Errorf("xtcp error writing data %v", err)
 			}
+		case <-sc.quit.Done():
+			// flush the queued messages
+			select {
+			case f := <-sc.mc:
+				if err := writeFrames(sc.fw, f, sc.mc); err != nil {
+					log.Errorf("xtcp error writing data %v", err)
+				}
+			default:
+			}
+			log.Infos("xtcp write loop closed")
+			return
 		}
 	}
 }
@@ -289,27 +553,36 @@ func (sc *ServerConn) writeLoop() {
 //
 // TODO optimize with sync.Pool
 func (sc *ServerConn) process(msg *message.Message) {
-	ctx := context.Background()
-	ctx = connection.NewContxet(ctx, sc)
+	ctx := sc.context()
 	nmd := message.DecodeMetadata(msg.Metas)
+	nmd.Set(connection.RemoteAddrKey, sc.RemoteAddr().String())
 	ctx = metadata.NewIncomingContext(ctx, nmd)
+	if atomic.LoadInt32(&sc.draining) == 1 {
+		sc.response(ctx, msg, nil, status.Error(codes.Unavailable, "xtcp: server is draining"))
+		return
+	}
 	srv, known := sc.server.services[msg.Service]
 	if !known {
 		log.Infosc(ctx, "xtcp: service not found error", zap.String("service", msg.Service))
 		if handler := sc.server.opts.unknownServiceHandler; handler != nil {
 			sc.wg.Add(1)
+			atomic.AddInt64(&sc.server.inflight, 1)
 			job := func() {
 				sc.wg.Done()
+				defer atomic.AddInt64(&sc.server.inflight, -1)
 				out, err := handler(ctx, msg.Service, msg.Method, msg.Data, sc.server.opts.unaryInt)
 				sc.response(ctx, msg, out, err)
 			}
 			sc.server.wps.Submit(job)
+			return
 		}
+		sc.response(ctx, msg, nil, status.Errorf(codes.Unimplemented, "xtcp: unknown service (%s)", msg.Service))
 		return
 	}
 	md, ok := srv.Method(msg.Method)
 	if !ok {
 		log.Errorwc(ctx, "xtcp: method not found error", "method", msg.Method)
+		sc.response(ctx, msg, nil, status.Errorf(codes.Unimplemented, "xtcp: unknown method (%s)", msg.Method))
 		return
 	}
 	df := func(v interface{}) error {
@@ -320,8 +593,10 @@ func (sc *ServerConn) process(msg *message.Message) {
 		return sc.codec.Unmarshal(msg.Data, v)
 	}
 	sc.wg.Add(1)
+	atomic.AddInt64(&sc.server.inflight, 1)
 	job := func() {
 		sc.wg.Done()
+		defer atomic.AddInt64(&sc.server.inflight, -1)
 		out, err := md.Handler(srv.Service(), ctx, df, sc.server.opts.unaryInt)
 		sc.response(ctx, msg, out, err)
 	}
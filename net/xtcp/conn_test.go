@@ -0,0 +1,62 @@
+package xtcp
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestConn(t *testing.T, opt ...Option) *ServerConn {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	raw, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { raw.Close() })
+	ds, _ := New(append(opt, BufferSizeOption(2))...)
+	s := ds.(*Server)
+	return newServerConn(1, s, raw.(*net.TCPConn), 2, s.opts.MaxFrameSize)
+}
+
+func TestSlowConsumer(t *testing.T) {
+	for _, tt := range []struct {
+		policy  string
+		errs    []error
+		dropped int64
+		closed  bool
+	}{
+		{PolicyDropNew, []error{nil, nil, ErrWouldBlock, ErrWouldBlock}, 2, false},
+		{PolicyDropOldest, []error{nil, nil, nil, nil}, 2, false},
+		{PolicyBlock, []error{nil, nil, ErrWouldBlock, ErrWouldBlock}, 2, false},
+		{PolicyDisconnect, []error{nil, nil, ErrWouldBlock, ErrWouldBlock}, 2, true},
+	} {
+		sc := newTestConn(t, SlowConsumer(tt.policy, 10*time.Millisecond, 2))
+		for i, want := range tt.errs {
+			if err := sc.Write([]byte{byte(i)}); err != want {
+				t.Errorf("%s: Write(%d) error = %v, want %v", tt.policy, i, err, want)
+			}
+		}
+		st := sc.Stats()
+		if st.Queued != 2 || st.Capacity != 2 || st.Dropped != tt.dropped {
+			t.Errorf("%s: stats = %+v", tt.policy, st)
+		}
+		if closed := sc.quit.HasFired(); closed != tt.closed {
+			t.Errorf("%s: closed = %v, want %v", tt.policy, closed, tt.closed)
+		}
+		if tt.policy == PolicyDropOldest {
+			if first := <-sc.mc; first.data[0] != 2 {
+				t.Errorf("%s: oldest queued = %v, want message 2", tt.policy, first.data)
+			}
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package xtcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/xsuners/mo/net/encoding"
+	mjson "github.com/xsuners/mo/net/encoding/json"
+	"github.com/xsuners/mo/net/encoding/proto"
+)
+
+// HandshakeVersion is the latest version of the handshake, the server
+// replies the lower of it and the version of the client.
+const HandshakeVersion = 1
+
+// Handshake is the first frame sent by a client, encoded in JSON.
+//
+// The legacy clients send the codec name only, optionally followed by the
+// compressors offered, e.g. "proto;compress=gzip,snappy". They are parsed
+// into a Handshake of version 0, and replied in the legacy form, e.g.
+// "proto;compress=gzip", or closed silently on errors.
+type Handshake struct {
+	Version       int      `json:"version"`
+	Codec         string   `json:"codec"`
+	Compressors   []string `json:"compressors,omitempty"` // in order of preference
+	ClientID      string   `json:"clientId,omitempty"`
+	ClientVersion string   `json:"clientVersion,omitempty"`
+	Token         string   `json:"token,omitempty"` // checked by HandshakeAuth
+}
+
+// HandshakeReply is the reply of a Handshake, encoded in JSON. A non-zero
+// Code, which is one of the grpc codes, means the conn is refused and closed
+// by the server.
+type HandshakeReply struct {
+	Version    int    `json:"version"`
+	Codec      string `json:"codec,omitempty"`
+	Compressor string `json:"compressor,omitempty"`
+	Code       int32  `json:"code,omitempty"`
+	Desc       string `json:"desc,omitempty"`
+}
+
+const compressParam = "compress"
+
+// structured reports whether data is a structured handshake or reply.
+func structured(data []byte) bool {
+	return len(data) > 0 && data[0] == '{'
+}
+
+// parseHandshake parses a handshake frame of any version.
+func parseHandshake(data []byte) (*Handshake, error) {
+	if !structured(data) {
+		name, compressors := parseLegacy(data)
+		return &Handshake{Codec: name, Compressors: compressors}, nil
+	}
+	h := &Handshake{}
+	if err := json.Unmarshal(data, h); err != nil {
+		return nil, fmt.Errorf("xtcp: invalid handshake: %w", err)
+	}
+	if h.Version < 1 {
+		return nil, fmt.Errorf("xtcp: invalid handshake version %d", h.Version)
+	}
+	return h, nil
+}
+
+// parseReply parses a handshake reply of any version.
+func parseReply(data []byte) (*HandshakeReply, error) {
+	if !structured(data) {
+		name, compressors := parseLegacy(data)
+		r := &HandshakeReply{Codec: name}
+		if len(compressors) > 0 {
+			r.Compressor = compressors[0]
+		}
+		return r, nil
+	}
+	r := &HandshakeReply{}
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, fmt.Errorf("xtcp: invalid handshake reply: %w", err)
+	}
+	return r, nil
+}
+
+// parseLegacy splits a legacy handshake frame into the codec name and the
+// compressors.
+func parseLegacy(data []byte) (name string, compressors []string) {
+	parts := strings.Split(string(data), ";")
+	name = parts[0]
+	for _, p := range parts[1:] {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) == 2 && strings.TrimSpace(kv[0]) == compressParam {
+			compressors = splitList(kv[1])
+		}
+	}
+	return
+}
+
+// formatLegacy formats a legacy handshake reply.
+func formatLegacy(name string, compressors ...string) []byte {
+	if len(compressors) == 0 {
+		return []byte(name)
+	}
+	return []byte(name + ";" + compressParam + "=" + strings.Join(compressors, ","))
+}
+
+var (
+	legacyProto = regexp.MustCompile("proto")
+	legacyJSON  = regexp.MustCompile("json")
+)
+
+// selectCodec returns the codec asked by h, the legacy clients are matched
+// loosely as before.
+func selectCodec(h *Handshake) encoding.Codec {
+	if h.Version > 0 {
+		return encoding.GetCodec(h.Codec)
+	}
+	switch {
+	case legacyProto.MatchString(h.Codec):
+		return encoding.GetCodec(proto.Name)
+	case legacyJSON.MatchString(h.Codec):
+		return encoding.GetCodec(mjson.Name)
+	}
+	return nil
+}
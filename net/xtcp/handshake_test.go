@@ -0,0 +1,97 @@
+package xtcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xsuners/mo/net/connection"
+	"github.com/xsuners/mo/net/message"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type tokenUser string
+
+func (tokenUser) Disconnected() {}
+
+// rawHandshake sends a handshake frame to addr and returns the reply, nil if
+// the conn is closed without reply.
+func rawHandshake(t *testing.T, addr, hs string) []byte {
+	t.Helper()
+	raw, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("net.Dial() error = %v", err)
+	}
+	defer raw.Close()
+	raw.SetDeadline(time.Now().Add(time.Second))
+	raw.Write(message.EncodeFrame([]byte(hs), false))
+	reply, err := message.Decode(raw)
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		t.Fatalf("read reply of %s error = %v", hs, err)
+	}
+	return reply
+}
+
+func TestHandshake(t *testing.T) {
+	users := make(chan connection.User, 1)
+	ds, stop := New(Port(0),
+		HandshakeAuth(func(ctx context.Context, h *Handshake) (connection.User, error) {
+			switch {
+			case h.Version == 0: // legacy clients authenticate later
+				return nil, nil
+			case h.Token == "bad":
+				return nil, errors.New("bad token")
+			}
+			return tokenUser(h.Token), nil
+		}),
+		ConnectHandler(func(c connection.Conn) {
+			if sc := c.(*ServerConn); sc.Handshake().ClientID == "test" {
+				users <- c.User()
+			}
+		}),
+	)
+	defer stop()
+	s := ds.(*Server)
+	go s.Serve()
+	<-s.Ready()
+	addr := fmt.Sprintf("127.0.0.1:%d", s.Port())
+
+	for _, tt := range []struct{ hs, reply string }{
+		{"proto", "proto"},
+		{"protobuf;compress=snappy,gzip", "proto;compress=snappy"},
+		{"xml", ""},
+		{`{"version":2,"codec":"json","compressors":["gzip"]}`, `{"version":1,"codec":"json","compressor":"gzip"}`},
+		{`{"version":1,"codec":"xml"}`, `{"version":1,"code":12,"desc":"xtcp: codec \"xml\" is not supported"}`},
+		{`{"codec":"proto"}`, `{"version":1,"code":3,"desc":"xtcp: invalid handshake version 0"}`},
+	} {
+		if reply := rawHandshake(t, addr, tt.hs); string(reply) != tt.reply {
+			t.Errorf("handshake %s reply = %s, want %s", tt.hs, reply, tt.reply)
+		}
+	}
+
+	cc, err := Dial(addr, WithClientInfo("test", "1.0"), WithToken("good"))
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer cc.Close()
+	select {
+	case u := <-users:
+		if u != tokenUser("good") {
+			t.Errorf("user = %v, want good", u)
+		}
+	case <-time.After(time.Second):
+		t.Error("conn is not connected")
+	}
+
+	if _, err = Dial(addr, WithToken("bad")); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Dial() with bad token error = %v, want Unauthenticated", err)
+	}
+}
@@ -0,0 +1,270 @@
+package xtcp
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/xsuners/mo/log"
+	"github.com/xsuners/mo/net/description"
+	"github.com/xsuners/mo/sync/event"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// State is the state of a Client.
+type State int
+
+const (
+	// Connecting means the client is dialing or handshaking.
+	Connecting State = iota
+	// Ready means the client has a conn to call through.
+	Ready
+	// TransientFailure means the client lost or failed to get a conn, and is
+	// waiting to connect again.
+	TransientFailure
+	// Shutdown means the client is closed.
+	Shutdown
+)
+
+func (s State) String() string {
+	switch s {
+	case Connecting:
+		return "CONNECTING"
+	case Ready:
+		return "READY"
+	case TransientFailure:
+		return "TRANSIENT_FAILURE"
+	case Shutdown:
+		return "SHUTDOWN"
+	default:
+		return "INVALID_STATE"
+	}
+}
+
+// Client keeps a conn to a xtcp server, it connects again with backoff when
+// the conn is lost, e.g. on server restarts. The handshake and the auth set
+// by WithAuth are done on every conn.
+type Client struct {
+	target string
+	opt    []DialOption
+	opts   dialOptions
+	quit   *event.Event
+	done   chan struct{}
+
+	mu       sync.Mutex
+	state    State
+	cc       *ClientConn
+	ready    chan struct{} // closed when state turns Ready
+	watchers []chan State
+}
+
+var _ description.ClientConnInterface = (*Client)(nil)
+
+// NewClient returns a client of the server at target, which is an address,
+// or a service name if WithResolver is set. It connects in background.
+func NewClient(target string, opt ...DialOption) *Client {
+	opts := defaultDialOptions()
+	for _, o := range opt {
+		o.apply(&opts)
+	}
+	c := &Client{
+		target: target,
+		opt:    opt,
+		opts:   opts,
+		quit:   event.NewEvent(),
+		done:   make(chan struct{}),
+		ready:  make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+// State returns the current state.
+func (c *Client) State() State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// Watch returns a channel the state changes are sent to, it is closed when
+// the client is closed. The changes are dropped if the channel is full.
+func (c *Client) Watch() <-chan State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan State, 16)
+	if c.state == Shutdown {
+		ch <- Shutdown
+		close(ch)
+		return ch
+	}
+	ch <- c.state
+	c.watchers = append(c.watchers, ch)
+	return ch
+}
+
+func (c *Client) setState(state State, cc *ClientConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == Shutdown {
+		return
+	}
+	c.cc = cc
+	if c.state != state {
+		if state == Ready {
+			close(c.ready)
+		} else if c.state == Ready {
+			c.ready = make(chan struct{})
+		}
+		c.state = state
+		for _, ch := range c.watchers {
+			select {
+			case ch <- state:
+			default:
+			}
+		}
+	}
+	if state == Shutdown {
+		for _, ch := range c.watchers {
+			close(ch)
+		}
+		c.watchers = nil
+	}
+}
+
+func (c *Client) run() {
+	defer close(c.done)
+	for attempt := 0; ; attempt++ {
+		c.setState(Connecting, nil)
+		cc, err := c.connect()
+		if err == nil {
+			attempt = 0
+			c.setState(Ready, cc)
+			select {
+			case <-cc.Done():
+				log.Infos("xtcp: client conn lost", zap.String("target", c.target))
+			case <-cc.GoAway():
+				// the server closes the conn once the calls in process
+				// are replied, connect again at once.
+				time.AfterFunc(c.opts.timeout, cc.Close)
+				continue
+			case <-c.quit.Done():
+				cc.Close()
+				return
+			}
+		} else {
+			log.Errors("xtcp: client connect error", zap.String("target", c.target), zap.Error(err))
+		}
+		c.setState(TransientFailure, nil)
+
+		t := time.NewTimer(c.backoff(attempt))
+		select {
+		case <-t.C:
+		case <-c.quit.Done():
+			t.Stop()
+			return
+		}
+	}
+}
+
+// backoff returns the delay before the connect following the given number
+// of failed ones.
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.opts.backoffBase
+	for i := 0; i < attempt && d < c.opts.backoffMax; i++ {
+		d *= 2
+	}
+	if d > c.opts.backoffMax {
+		d = c.opts.backoffMax
+	}
+	// jitter of ±20%
+	return time.Duration(float64(d) * (0.8 + 0.4*rand.Float64()))
+}
+
+func (c *Client) connect() (*ClientConn, error) {
+	addr, err := c.resolve()
+	if err != nil {
+		return nil, err
+	}
+	cc, err := Dial(addr, c.opt...)
+	if err != nil {
+		return nil, err
+	}
+	if auth := c.opts.auth; auth != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), c.opts.timeout)
+		defer cancel()
+		if err = auth(ctx, cc); err != nil {
+			cc.Close()
+			return nil, fmt.Errorf("xtcp: auth error: %w", err)
+		}
+	}
+	return cc, nil
+}
+
+func (c *Client) resolve() (string, error) {
+	if c.opts.resolver == nil {
+		return c.target, nil
+	}
+	services, err := c.opts.resolver.Resolve(c.target)
+	if err != nil {
+		return "", err
+	}
+	if len(services) == 0 {
+		return "", fmt.Errorf("xtcp: no instance of service (%s)", c.target)
+	}
+	svc := services[rand.Intn(len(services))]
+	return net.JoinHostPort(svc.IP, strconv.Itoa(svc.Port)), nil
+}
+
+// conn returns the current conn, it waits for the next one if the client is
+// set WithWaitForReady.
+func (c *Client) conn(ctx context.Context) (*ClientConn, error) {
+	for {
+		c.mu.Lock()
+		state, cc, ready := c.state, c.cc, c.ready
+		c.mu.Unlock()
+		switch {
+		case state == Ready:
+			return cc, nil
+		case state == Shutdown:
+			return nil, status.Error(codes.Canceled, "xtcp: client is closed")
+		case !c.opts.waitForReady:
+			return nil, status.Errorf(codes.Unavailable, "xtcp: client is %v", state)
+		}
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return nil, status.FromContextError(ctx.Err()).Err()
+		case <-c.quit.Done():
+			return nil, status.Error(codes.Canceled, "xtcp: client is closed")
+		}
+	}
+}
+
+// Invoke calls method through the current conn.
+func (c *Client) Invoke(ctx context.Context, method string, args interface{}, reply interface{}, opts ...description.CallOption) error {
+	cc, err := c.conn(ctx)
+	if err != nil {
+		return err
+	}
+	return cc.Invoke(ctx, method, args, reply, opts...)
+}
+
+// NewStream is not supported.
+func (c *Client) NewStream(ctx context.Context, desc *description.StreamDesc, method string, opts ...description.CallOption) (description.ClientStream, error) {
+	return nil, status.Error(codes.Unimplemented, "xtcp: stream is not supported")
+}
+
+// Close closes the client and its conn.
+func (c *Client) Close() {
+	if !c.quit.Fire() {
+		return
+	}
+	<-c.done
+	c.setState(Shutdown, nil)
+}
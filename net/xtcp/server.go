@@ -3,18 +3,25 @@ package xtcp
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"net"
+	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/xsuners/mo/log"
 	"github.com/xsuners/mo/misc/ip"
 	"github.com/xsuners/mo/naming"
 	"github.com/xsuners/mo/net/connection"
+	"github.com/xsuners/mo/net/connection/hub"
 	"github.com/xsuners/mo/net/description"
+	"github.com/xsuners/mo/net/message"
+	"github.com/xsuners/mo/net/proxyproto"
+	"github.com/xsuners/mo/sync/event"
 	"github.com/xsuners/mo/sync/workerpool"
 	"go.uber.org/zap"
 )
@@ -27,7 +34,27 @@ type Options struct {
 	WorkerSize     int `ini-name:"workerSize" long:"tcp-worker-size" description:"tcp worker size"` // numbers of worker go-routines
 	BufferSize     int `ini-name:"bufferSize" long:"tcp-buffer-size" description:"tcp buffer size"` // size of buffered channel
 	MaxConnections int `ini-name:"maxConnections" long:"tcp-max-connections" description:"tcp max connections"`
-	Port           int
+	Port           int `ini-name:"port" long:"tcp-port" description:"tcp port"`
+	MaxFrameSize   int `ini-name:"maxFrameSize" long:"tcp-max-frame-size" description:"tcp max bytes of a frame payload"`
+
+	TLSCert              string        `ini-name:"tlsCert" long:"tcp-tls-cert" description:"tcp tls certificate file, tls is enabled if set"`
+	TLSKey               string        `ini-name:"tlsKey" long:"tcp-tls-key" description:"tcp tls key file"`
+	TLSClientCA          string        `ini-name:"tlsClientCA" long:"tcp-tls-client-ca" description:"tcp tls CA file to verify the client certificates with"`
+	TLSRequireClientCert bool          `ini-name:"tlsRequireClientCert" long:"tcp-tls-require-client-cert" description:"tcp tls refuses the clients without a certificate verified by the client CA"`
+	HandshakeTimeout     time.Duration `ini-name:"handshakeTimeout" long:"tcp-handshake-timeout" description:"tcp max time of the tls and codec handshakes, 0 means no limit"`
+
+	ProxyProtocol string `ini-name:"proxyProtocol" long:"tcp-proxy-protocol" description:"tcp trusted CIDRs of the load balancers sending PROXY protocol headers, comma separated, empty disables it"`
+
+	Compressors       string `ini-name:"compressors" long:"tcp-compressors" description:"tcp compressors the clients can negotiate, comma separated"`
+	CompressThreshold int    `ini-name:"compressThreshold" long:"tcp-compress-threshold" description:"tcp min message bytes to compress"`
+
+	HeartbeatInterval time.Duration `ini-name:"heartbeatInterval" long:"tcp-heartbeat-interval" description:"tcp interval of checking idle connections, 0 disables the check"`
+	IdleTimeout       time.Duration `ini-name:"idleTimeout" long:"tcp-idle-timeout" description:"tcp connections without inbound frames for it are closed, 0 means never"`
+	PongTimeout       time.Duration `ini-name:"pongTimeout" long:"tcp-pong-timeout" description:"tcp idle connections are pinged and closed if no reply in it, 0 disables ping"`
+
+	WritePolicy  string        `ini-name:"writePolicy" long:"tcp-write-policy" description:"tcp policy when a write queue is full: dropNew, dropOldest, block or disconnect"`
+	WriteTimeout time.Duration `ini-name:"writeTimeout" long:"tcp-write-timeout" description:"tcp max time to wait for a full write queue with the block policy"`
+	MaxDrops     int           `ini-name:"maxDrops" long:"tcp-max-drops" description:"tcp drops before a connection is closed with the disconnect policy"`
 
 	tlsCfg                *tls.Config
 	unaryInt              description.UnaryServerInterceptor
@@ -35,6 +62,8 @@ type Options struct {
 	onconnect             func(connection.Conn)
 	onclose               func(connection.Conn)
 	unknownServiceHandler Handler
+	handshakeAuth         HandshakeAuthFunc
+	hub                   *hub.Hub
 	// streamInt             StreamServerInterceptor
 	// chainStreamInts       []StreamServerInterceptor
 	// ip                    string
@@ -46,19 +75,118 @@ var defaultOptions = Options{
 	WorkerSize:     10000,
 	MaxConnections: 1000,
 	Port:           6000,
+	MaxFrameSize:   message.MaxBytes,
+
+	HandshakeTimeout: 10 * time.Second,
+
+	Compressors:       "gzip,snappy",
+	CompressThreshold: 1024,
+
+	HeartbeatInterval: time.Minute,
+	IdleTimeout:       time.Minute,
+
+	WritePolicy:  PolicyDropNew,
+	WriteTimeout: time.Second,
+	MaxDrops:     100,
 }
 
+// The policies applied when the write queue of a conn is full.
+const (
+	// PolicyDropNew drops the message written, Write fails with
+	// ErrWouldBlock.
+	PolicyDropNew = "dropNew"
+	// PolicyDropOldest drops the oldest message queued to make room.
+	PolicyDropOldest = "dropOldest"
+	// PolicyBlock waits WriteTimeout for room, then drops the message
+	// written like PolicyDropNew.
+	PolicyBlock = "block"
+	// PolicyDisconnect drops the message written like PolicyDropNew, and
+	// closes the conn with ErrSlowConsumer once MaxDrops are dropped.
+	PolicyDisconnect = "disconnect"
+)
+
+const drainPollInterval = 10 * time.Millisecond
+
 // Option sets server options.
 type Option func(*Options)
 
 // TLSCredsOption returns a Option that will set TLS credentials for server
-// connections.
+// connections, it overrides the files set by TLSFiles.
 func TLSCredsOption(config *tls.Config) Option {
 	return func(o *Options) {
 		o.tlsCfg = config
 	}
 }
 
+// TLSFiles returns a Option that enables TLS with the certificate and key
+// files.
+func TLSFiles(cert, key string) Option {
+	return func(o *Options) {
+		o.TLSCert = cert
+		o.TLSKey = key
+	}
+}
+
+// TLSClientCA returns a Option that verifies the client certificates with
+// the CA file, the clients without one are refused if require is set.
+func TLSClientCA(file string, require bool) Option {
+	return func(o *Options) {
+		o.TLSClientCA = file
+		o.TLSRequireClientCert = require
+	}
+}
+
+// HandshakeTimeout returns a Option that bounds the TLS and codec
+// handshakes of a conn, the conns not done in time are closed.
+func HandshakeTimeout(d time.Duration) Option {
+	return func(o *Options) {
+		o.HandshakeTimeout = d
+	}
+}
+
+// ProxyProtocol returns a Option that parses the PROXY protocol headers
+// sent by the load balancers in the CIDRs, so that the conns report the
+// addresses of the clients.
+func ProxyProtocol(cidrs ...string) Option {
+	return func(o *Options) {
+		o.ProxyProtocol = strings.Join(cidrs, ",")
+	}
+}
+
+// tlsConfig returns the TLS config set by TLSCredsOption or loaded from the
+// files, nil if TLS is not enabled.
+func (o *Options) tlsConfig() (*tls.Config, error) {
+	if o.tlsCfg != nil {
+		return o.tlsCfg, nil
+	}
+	if o.TLSCert == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(o.TLSCert, o.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("xtcp: load tls key pair error: %w", err)
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if o.TLSClientCA != "" {
+		pem, err := os.ReadFile(o.TLSClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("xtcp: read tls client CA error: %w", err)
+		}
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("xtcp: no certificate in tls client CA (%s)", o.TLSClientCA)
+		}
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+		if o.TLSRequireClientCert {
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return cfg, nil
+}
+
 // WorkerSizeOption returns a Option that will set the number of go-routines
 // in WorkerPool.
 func WorkerSizeOption(workerSz int) Option {
@@ -82,6 +210,60 @@ func MaxConnections(count int) Option {
 	}
 }
 
+// MaxFrameSize returns a Option that sets the max bytes of a frame payload,
+// the conns sending larger frames are closed.
+func MaxFrameSize(n int) Option {
+	return func(o *Options) {
+		o.MaxFrameSize = n
+	}
+}
+
+// Compressors returns a Option that sets the compressors the clients can
+// negotiate, none means compression is disabled.
+func Compressors(names ...string) Option {
+	return func(o *Options) {
+		o.Compressors = strings.Join(names, ",")
+	}
+}
+
+// CompressThreshold returns a Option that sets the min size of the messages
+// to compress, the smaller ones are sent as they are.
+func CompressThreshold(n int) Option {
+	return func(o *Options) {
+		o.CompressThreshold = n
+	}
+}
+
+// Keepalive returns a Option that sets how the idle connections are handled.
+// Every interval the connections without inbound frames for idle are closed,
+// and if pong is not 0, the ones without inbound frames for interval are
+// pinged and closed if they do not reply in pong.
+func Keepalive(interval, idle, pong time.Duration) Option {
+	return func(o *Options) {
+		o.HeartbeatInterval = interval
+		o.IdleTimeout = idle
+		o.PongTimeout = pong
+	}
+}
+
+// SlowConsumer returns a Option that sets the policy applied when the write
+// queue of a conn is full, timeout is used by PolicyBlock and maxDrops by
+// PolicyDisconnect.
+func SlowConsumer(policy string, timeout time.Duration, maxDrops int) Option {
+	return func(o *Options) {
+		o.WritePolicy = policy
+		o.WriteTimeout = timeout
+		o.MaxDrops = maxDrops
+	}
+}
+
+// Hub returns a Option that keeps the connections in h.
+func Hub(h *hub.Hub) Option {
+	return func(o *Options) {
+		o.hub = h
+	}
+}
+
 // ConnectHandler returns a Option that will set callback to call when new
 // client connected.
 func ConnectHandler(cb func(connection.Conn)) Option {
@@ -91,7 +273,7 @@ func ConnectHandler(cb func(connection.Conn)) Option {
 }
 
 // CloseHandler returns a Option that will set callback to call when client
-// closed.
+// closed, the reason is told by (*ServerConn).CloseReason.
 func CloseHandler(cb func(connection.Conn)) Option {
 	return func(o *Options) {
 		o.onclose = cb
@@ -132,6 +314,20 @@ func UnknownServiceHandler(handler Handler) Option {
 	}
 }
 
+// HandshakeAuthFunc checks the handshake of a conn before it is served, the
+// conn is refused with the code of the error returned, Unauthenticated if the
+// error has no code. The user returned, if any, is set to the conn as if by
+// Auth.
+type HandshakeAuthFunc func(ctx context.Context, h *Handshake) (connection.User, error)
+
+// HandshakeAuth returns a Option that sets f to check the handshakes, the
+// legacy ones included, whose version is 0 and have no token.
+func HandshakeAuth(f HandshakeAuthFunc) Option {
+	return func(o *Options) {
+		o.handshakeAuth = f
+	}
+}
+
 // // IP .
 // func IP(ip string) Option {
 // 	return func(o *options) {
@@ -155,6 +351,8 @@ type Server struct {
 	services map[string]*description.ServiceInfo // service name -> service info
 	lis      map[net.Listener]bool
 	wps      *workerpool.WorkerPool
+	ready    *event.Event
+	inflight int64 // numbers of requests in process
 	// ctx      context.Context
 	// cancel   context.CancelFunc
 	// onconnect             func(connection.Conn)
@@ -176,8 +374,11 @@ func New(opt ...Option) (description.Server, func()) {
 		lis:      make(map[net.Listener]bool),
 		conns:    make(map[*ServerConn]bool),
 		services: make(map[string]*description.ServiceInfo),
+		ready:    event.NewEvent(),
 	}
-	chainUnaryServerInterceptors(s)
+	// Prepend opts.unaryInt to the chaining interceptors if it exists, since unaryInt will
+	// be executed before any other chained interceptors.
+	s.opts.unaryInt = description.ChainUnaryServer(append([]description.UnaryServerInterceptor{s.opts.unaryInt}, s.opts.chainUnaryInts...)...)
 	return s, func() {
 		log.Info("xtcp is closing...")
 		s.Stop()
@@ -185,40 +386,6 @@ func New(opt ...Option) (description.Server, func()) {
 	}
 }
 
-// chainUnaryServerInterceptors chains all unary server interceptors into one.
-func chainUnaryServerInterceptors(s *Server) {
-	// Prepend opts.unaryInt to the chaining interceptors if it exists, since unaryInt will
-	// be executed before any other chained interceptors.
-	interceptors := s.opts.chainUnaryInts
-	if s.opts.unaryInt != nil {
-		interceptors = append([]description.UnaryServerInterceptor{s.opts.unaryInt}, s.opts.chainUnaryInts...)
-	}
-
-	var chainedInt description.UnaryServerInterceptor
-	if len(interceptors) == 0 {
-		chainedInt = nil
-	} else if len(interceptors) == 1 {
-		chainedInt = interceptors[0]
-	} else {
-		chainedInt = func(ctx context.Context, req interface{}, info *description.UnaryServerInfo, handler description.UnaryHandler) (interface{}, error) {
-			return interceptors[0](ctx, req, info, getChainUnaryHandler(interceptors, 0, info, handler))
-		}
-	}
-
-	s.opts.unaryInt = chainedInt
-}
-
-// getChainUnaryHandler recursively generate the chained UnaryHandler
-func getChainUnaryHandler(interceptors []description.UnaryServerInterceptor, curr int, info *description.UnaryServerInfo, finalHandler description.UnaryHandler) description.UnaryHandler {
-	if curr == len(interceptors)-1 {
-		return finalHandler
-	}
-
-	return func(ctx context.Context, req interface{}) (interface{}, error) {
-		return interceptors[curr+1](ctx, req, info, getChainUnaryHandler(interceptors, curr+1, info, finalHandler))
-	}
-}
-
 var _ description.ServiceRegistrar = (*Server)(nil)
 
 // RegisterService .
@@ -246,10 +413,18 @@ func (s *Server) Register(ss interface{}, sds ...*description.ServiceDesc) {
 
 // Serve .
 func (s *Server) Serve() error {
+	tlsCfg, err := s.opts.tlsConfig()
+	if err != nil {
+		return err
+	}
 	l, err := net.Listen("tcp", fmt.Sprintf(":%d", s.opts.Port))
 	if err != nil {
 		return err
 	}
+	if l, err = proxyproto.Wrap(l, s.opts.ProxyProtocol); err != nil {
+		l.Close()
+		return err
+	}
 	s.mu.Lock()
 	if s.lis == nil { // mines server is closed
 		s.mu.Unlock()
@@ -260,7 +435,9 @@ func (s *Server) Serve() error {
 		return errors.New("xtcp: listener already exist")
 	}
 	s.lis[l] = true
+	s.opts.Port = l.Addr().(*net.TCPAddr).Port
 	s.mu.Unlock()
+	s.ready.Fire()
 
 	defer func() {
 		s.mu.Lock()
@@ -299,17 +476,20 @@ func (s *Server) Serve() error {
 		}
 		tempDelay = 0
 
-		if len(s.conns) >= s.opts.MaxConnections {
-			log.Warnf("max connections size %d, refuse", len(s.conns))
+		s.mu.Lock()
+		conns, maxConns, bufferSize, maxFrame := len(s.conns), s.opts.MaxConnections, s.opts.BufferSize, s.opts.MaxFrameSize
+		s.mu.Unlock()
+		if conns >= maxConns {
+			log.Warnf("max connections size %d, refuse", conns)
 			raw.Close()
 			continue
 		}
 
-		if s.opts.tlsCfg != nil {
-			raw = tls.Server(raw, s.opts.tlsCfg)
+		if tlsCfg != nil { // handshaked in serveConn
+			raw = tls.Server(raw, tlsCfg)
 		}
 
-		sc := newServerConn(connection.GenID(), s, raw.(*net.TCPConn))
+		sc := newServerConn(connection.GenID(), s, raw, bufferSize, maxFrame)
 
 		s.wg.Add(1)
 		go func() {
@@ -341,9 +521,30 @@ func (s *Server) removeConn(c *ServerConn) {
 }
 
 func (s *Server) serveConn(sc *ServerConn) {
+	s.mu.Lock()
+	timeout := s.opts.HandshakeTimeout
+	s.mu.Unlock()
+	if timeout > 0 {
+		sc.raw.SetDeadline(time.Now().Add(timeout))
+	}
+	if tc, ok := sc.raw.(*tls.Conn); ok {
+		if err := tc.Handshake(); err != nil {
+			log.Infos("xtcp: tls handshake error", zap.Stringer("remote", sc.raw.RemoteAddr()), zap.Error(err))
+			sc.raw.Close()
+			return
+		}
+		sc.peer = connection.NewPeer(tc.ConnectionState())
+	}
 	if err := sc.handshake(); err != nil {
+		sc.raw.Close()
 		return
 	}
+	sc.raw.SetDeadline(time.Time{})
+	if h := sc.server.opts.hub; h != nil {
+		h.Add(sc)
+		h.Bind(sc) // authed by the handshake
+		defer h.Remove(sc)
+	}
 	// on connect
 	if cb := sc.server.opts.onconnect; cb != nil {
 		cb(sc)
@@ -374,13 +575,127 @@ func (s *Server) Naming(nm naming.Naming) error {
 	return nil
 }
 
-// Stop .
-func (s *Server) Stop() {
+// Services .
+func (s *Server) Services() map[string]*description.ServiceInfo {
+	return s.services
+}
+
+// Port .
+func (s *Server) Port() int {
 	s.mu.Lock()
-	if s.lis == nil {
-		s.mu.Unlock()
-		return
+	defer s.mu.Unlock()
+	return s.opts.Port
+}
+
+// Ready .
+func (s *Server) Ready() <-chan struct{} {
+	return s.ready.Done()
+}
+
+// Drain stops accepting new connections and sends GOAWAY to the clients, the
+// requests received afterwards are refused. It then waits for the requests in
+// process to be done, closes the connections, and waits for their write loops
+// to flush the queued messages.
+func (s *Server) Drain(ctx context.Context) error {
+	s.closeListeners()
+	for _, c := range s.snapshot() {
+		c.goAway()
+	}
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for !s.drained() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
 	}
+	for _, c := range s.snapshot() {
+		c.Close()
+	}
+	for len(s.snapshot()) > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// Stats returns the write queue stats of the conns.
+func (s *Server) Stats() []ConnStats {
+	conns := s.snapshot()
+	stats := make([]ConnStats, 0, len(conns))
+	for _, c := range conns {
+		stats = append(stats, c.Stats())
+	}
+	return stats
+}
+
+func (s *Server) snapshot() (conns []*ServerConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	return
+}
+
+// drained reports whether there is neither request in process nor message
+// waiting to be written.
+func (s *Server) drained() bool {
+	if atomic.LoadInt64(&s.inflight) > 0 {
+		return false
+	}
+	for _, c := range s.snapshot() {
+		if c.queued() > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Options returns the options of the server, including the reloaded ones.
+func (s *Server) Options() Options {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.opts
+}
+
+// Reload applies the reloadable options, MaxConnections and BufferSize, the
+// zero values are ignored. BufferSize takes effect on new connections only.
+func (s *Server) Reload(opts Options) error {
+	commit, _, err := s.PrepareReload(opts)
+	if err != nil {
+		return err
+	}
+	commit()
+	return nil
+}
+
+// PrepareReload checks opts without applying them, commit applies them as
+// Reload does and abort discards them.
+func (s *Server) PrepareReload(opts Options) (commit, abort func(), err error) {
+	if opts.MaxConnections < 0 || opts.BufferSize < 0 {
+		return nil, nil, fmt.Errorf("xtcp: invalid options, max connections %d, buffer size %d", opts.MaxConnections, opts.BufferSize)
+	}
+	commit = func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if opts.MaxConnections > 0 {
+			s.opts.MaxConnections = opts.MaxConnections
+		}
+		if opts.BufferSize > 0 {
+			s.opts.BufferSize = opts.BufferSize
+		}
+	}
+	return commit, func() {}, nil
+}
+
+func (s *Server) closeListeners() {
+	s.mu.Lock()
 	listeners := s.lis
 	s.lis = nil
 	s.mu.Unlock()
@@ -389,6 +704,11 @@ func (s *Server) Stop() {
 		l.Close()
 		log.Infof("stop accepting at address %s", l.Addr().String())
 	}
+}
+
+// Stop .
+func (s *Server) Stop() {
+	s.closeListeners()
 
 	s.mu.Lock()
 	for c := range s.conns {
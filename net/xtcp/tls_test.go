@@ -0,0 +1,120 @@
+package xtcp
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xsuners/mo/net/connection"
+)
+
+// issue returns a certificate of name signed by parent, self signed if parent
+// is nil.
+func issue(t *testing.T, name string, parent *tls.Certificate) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := tmpl, interface{}(key)
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// writePEM writes the certificate and its key to dir and returns the paths.
+func writePEM(t *testing.T, dir, name string, cert tls.Certificate) (certFile, keyFile string) {
+	t.Helper()
+	key, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}), 0600)
+	return
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, "ca", nil)
+	caFile, _ := writePEM(t, dir, "ca", ca)
+	certFile, keyFile := writePEM(t, dir, "server", issue(t, "server", &ca))
+	client := issue(t, "alice", &ca)
+
+	peers := make(chan *connection.Peer, 1)
+	ds, stop := New(Port(0),
+		TLSFiles(certFile, keyFile),
+		TLSClientCA(caFile, true),
+		HandshakeTimeout(200*time.Millisecond),
+		HandshakeAuth(func(ctx context.Context, h *Handshake) (connection.User, error) {
+			peer, _ := connection.PeerFromContext(ctx)
+			peers <- peer
+			return nil, nil
+		}),
+	)
+	defer stop()
+	s := ds.(*Server)
+	go s.Serve()
+	<-s.Ready()
+	addr := fmt.Sprintf("127.0.0.1:%d", s.Port())
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+	cc, err := Dial(addr, WithTLS(&tls.Config{RootCAs: roots, Certificates: []tls.Certificate{client}}))
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer cc.Close()
+	if peer := <-peers; peer == nil || peer.CommonName != "alice" {
+		t.Errorf("peer = %+v, want alice", peer)
+	}
+
+	if cc, err := Dial(addr, WithTLS(&tls.Config{RootCAs: roots})); err == nil {
+		cc.Close()
+		t.Error("Dial() without client certificate error = nil")
+	}
+
+	// a client never handshaking is closed
+	raw, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("net.Dial() error = %v", err)
+	}
+	defer raw.Close()
+	raw.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err = raw.Read(make([]byte, 1)); err == nil {
+		t.Error("Read() of a silent conn error = nil")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Error("silent conn is not closed by the handshake timeout")
+	}
+}
@@ -0,0 +1,102 @@
+package xws
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/xsuners/mo/log"
+	"github.com/xsuners/mo/net/encoding"
+	_ "github.com/xsuners/mo/net/encoding/gzip" // register gzip compressor
+	"github.com/xsuners/mo/net/encoding/json"
+	"github.com/xsuners/mo/net/encoding/proto"
+	_ "github.com/xsuners/mo/net/encoding/snappy" // register snappy compressor
+	"go.uber.org/zap"
+)
+
+// The clients select the codec by the subprotocol, "protobuf" or "json", a
+// compressor can be appended with "+", e.g. "protobuf+gzip". The first one
+// offered the server supports is selected. Once a compressor is selected,
+// every binary message is prefixed with a flag byte telling whether the rest
+// is compressed.
+const (
+	flagRaw        byte = 0
+	flagCompressed byte = 1
+)
+
+var errFlag = errors.New("xws: invalid compression flag")
+
+var (
+	protoRegexp = regexp.MustCompile("proto")
+	jsonRegexp  = regexp.MustCompile("json")
+)
+
+// selectProtocol returns the subprotocol answered to the client, and the
+// codec and compressor of it. The codec is nil if nothing is supported.
+func selectProtocol(b []byte, allowed []string) (protocol string, codec encoding.Codec, comp encoding.Compressor) {
+	for _, p := range bytes.Split(b, []byte(",")) {
+		parts := strings.SplitN(string(bytes.TrimSpace(p)), "+", 2)
+		switch {
+		case protoRegexp.MatchString(parts[0]):
+			protocol, codec = "protobuf", encoding.GetCodec(proto.Name)
+		case jsonRegexp.MatchString(parts[0]):
+			protocol, codec = "json", encoding.GetCodec(json.Name)
+		default:
+			continue
+		}
+		if len(parts) == 1 {
+			return protocol, codec, nil
+		}
+		if comp = encoding.SelectCompressor(parts[1:], allowed); comp != nil {
+			return protocol + "+" + comp.Name(), codec, comp
+		}
+	}
+	return "", nil, nil
+}
+
+func splitList(s string) (list []string) {
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return
+}
+
+// encodePayload prefixes data with the flag byte, data is compressed by comp
+// if it has threshold bytes at least and compressing makes it smaller. data
+// is returned as it is if comp is nil.
+func encodePayload(comp encoding.Compressor, threshold int, data []byte) []byte {
+	if comp == nil {
+		return data
+	}
+	if len(data) >= threshold {
+		out, err := encoding.Compress(comp, data)
+		if err != nil {
+			log.Errors("xws: compress error", zap.Error(err))
+		} else if len(out) < len(data) {
+			return append([]byte{flagCompressed}, out...)
+		}
+	}
+	return append([]byte{flagRaw}, data...)
+}
+
+// decodePayload strips the flag byte and decompresses data of max bytes at
+// most if it is flagged compressed. data is returned as it is if comp is nil.
+func decodePayload(comp encoding.Compressor, data []byte, max int) ([]byte, error) {
+	if comp == nil {
+		return data, nil
+	}
+	if len(data) == 0 {
+		return nil, errFlag
+	}
+	switch data[0] {
+	case flagRaw:
+		return data[1:], nil
+	case flagCompressed:
+		return encoding.Decompress(comp, data[1:], max)
+	default:
+		return nil, errFlag
+	}
+}
@@ -0,0 +1,57 @@
+package xws
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/xsuners/mo/net/encoding"
+	"github.com/xsuners/mo/net/encoding/gzip"
+	"github.com/xsuners/mo/net/encoding/snappy"
+)
+
+func TestSelectProtocol(t *testing.T) {
+	allowed := []string{gzip.Name}
+	for _, tt := range []struct {
+		header   string
+		protocol string
+		comp     string
+	}{
+		{"protobuf", "protobuf", ""},
+		{"json", "json", ""},
+		{"protobuf+gzip", "protobuf+gzip", gzip.Name},
+		{"json+snappy, json+gzip", "json+gzip", gzip.Name},
+		{"protobuf+snappy", "", ""},
+		{"xml", "", ""},
+	} {
+		protocol, codec, comp := selectProtocol([]byte(tt.header), allowed)
+		if protocol != tt.protocol || (codec != nil) != (protocol != "") {
+			t.Errorf("selectProtocol(%q) = %q, %v", tt.header, protocol, codec)
+		}
+		name := ""
+		if comp != nil {
+			name = comp.Name()
+		}
+		if name != tt.comp {
+			t.Errorf("selectProtocol(%q) compressor = %q, want %q", tt.header, name, tt.comp)
+		}
+	}
+}
+
+func TestPayload(t *testing.T) {
+	_, _, comp := selectProtocol([]byte("protobuf+"+snappy.Name), []string{snappy.Name})
+	small := []byte("hello")
+	large := bytes.Repeat([]byte("hello "), 1000)
+	for _, data := range [][]byte{small, large} {
+		payload := encodePayload(comp, 100, data)
+		if compressed := payload[0] == flagCompressed; compressed != (len(data) >= 100) {
+			t.Errorf("len %d: compressed = %v", len(data), compressed)
+		}
+		out, err := decodePayload(comp, payload, len(large))
+		if err != nil || !bytes.Equal(out, data) {
+			t.Errorf("len %d: decodePayload() = %d bytes, %v", len(data), len(out), err)
+		}
+	}
+	if _, err := decodePayload(comp, encodePayload(comp, 100, large), len(large)-1); err != encoding.ErrTooLarge {
+		t.Errorf("decodePayload() of a large payload error = %v, want %v", err, encoding.ErrTooLarge)
+	}
+}
@@ -1,17 +1,24 @@
 package xws
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
 	"github.com/gobwas/ws"
 	"github.com/xsuners/mo/log"
 	"github.com/xsuners/mo/net/connection"
 	"github.com/xsuners/mo/net/encoding"
+	"github.com/xsuners/mo/net/encoding/json"
 	"github.com/xsuners/mo/net/message"
+	"github.com/xsuners/mo/sync/event"
 	"go.uber.org/zap"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/protobuf/proto"
@@ -27,13 +34,59 @@ import (
 
 var _ connection.Conn = (*wrappedConn)(nil)
 
+var (
+	// ErrWouldBlock is returned by Write when the message is dropped since
+	// the write queue is full.
+	ErrWouldBlock = errors.New("xws: would block")
+
+	errClosed = errors.New("xws: conn is closed")
+)
+
+const (
+	// closeTimeout is the max time to send the queued messages and the
+	// close frame, and then to wait for the close frame of the client.
+	closeTimeout = time.Second
+	// maxCloseReason is the max bytes of a close reason, which is followed
+	// by the code in a control frame of 125 bytes at most.
+	maxCloseReason = 123
+)
+
+// ConnStats is the stats of the write queue of a conn.
+type ConnStats struct {
+	ID       int64
+	Queued   int   // messages waiting to be written
+	Capacity int   // size of the queue
+	Dropped  int64 // messages dropped since the queue is full
+}
+
+// frame is a data frame queued to be written.
+type frame struct {
+	op      ws.OpCode
+	payload []byte
+	deflate bool
+}
+
 type wrappedConn struct {
-	id     int64
-	codec  encoding.Codec
-	user   connection.User
-	raw    net.Conn
-	server *Server
-	closed bool
+	id      int64
+	codec   encoding.Codec
+	comp    encoding.Compressor
+	flate   *flater     // nil if permessage-deflate is not negotiated
+	md      metadata.MD // set by UpgradeAuth
+	user    connection.User
+	raw     net.Conn
+	server  *Server
+	bw      *bufio.Writer
+	mc      chan frame
+	quit    *event.Event
+	done    chan struct{} // closed once writeLoop returns
+	dropped int64
+
+	wmu       sync.Mutex // guards the writes to bw and closeSent
+	closeSent bool
+
+	mu     sync.Mutex // guards the following
+	code   ws.StatusCode
+	reason string
 	// ctx    context.Context
 	// cancel context.CancelFunc
 }
@@ -43,40 +96,194 @@ func newWrappedConn(id int64, s *Server, c net.Conn) *wrappedConn {
 		id:     id,
 		raw:    c,
 		server: s,
+		bw:     bufio.NewWriterSize(c, message.DefaultBufferSize),
+		mc:     make(chan frame, s.opts.BufferSize),
+		quit:   event.NewEvent(),
+		done:   make(chan struct{}),
 	}
 	// ctx := context.Background()
 	// wc.ctx, wc.cancel = context.WithCancel(ctx)
 	return wc
 }
 
+// Close sends the queued messages and a going away close frame, the conn is
+// closed once the client echoes it, or closeTimeout later.
 func (wc *wrappedConn) Close() {
 	// wc.cancel()
-	if wc.closed {
+	wc.closeWith(ws.StatusGoingAway, "")
+}
+
+// closeWith stops the writes, the queued messages are sent before a close
+// frame of code, which has no body if code is empty.
+func (wc *wrappedConn) closeWith(code ws.StatusCode, reason string) {
+	wc.mu.Lock()
+	if wc.quit.HasFired() {
+		wc.mu.Unlock()
 		return
 	}
+	if len(reason) > maxCloseReason {
+		reason = reason[:maxCloseReason]
+	}
+	wc.code, wc.reason = code, reason
+	wc.quit.Fire()
+	wc.mu.Unlock()
+
 	if wc.user != nil {
 		wc.user.Disconnected()
 	}
-	wc.closed = true
-	wc.raw.Close()
 }
 
-func (wc *wrappedConn) Write(message []byte) error {
-	if wc.closed {
-		return errors.New("xws: conn is closed")
+// Write queues a message to the client, it is compressed if a compressor is
+// negotiated and it is large enough, or deflated if permessage-deflate is
+// negotiated instead. The messages of the JSON codec are sent in text frames
+// unless they are compressed. A full queue is handled by the WritePolicy.
+func (wc *wrappedConn) Write(data []byte) error {
+	if wc.quit.HasFired() {
+		return errClosed
+	}
+	data = encodePayload(wc.comp, wc.server.opts.CompressThreshold, data)
+	f := frame{op: ws.OpBinary, payload: data}
+	if wc.comp == nil && wc.codec.Name() == json.Name {
+		f.op = ws.OpText
+	}
+	f.deflate = wc.flate != nil && len(data) >= wc.flate.threshold &&
+		(wc.comp == nil || data[0] != flagCompressed)
+	select {
+	case wc.mc <- f:
+		return nil
+	default:
+	}
+
+	opts := &wc.server.opts
+	switch opts.WritePolicy {
+	case PolicyDropOldest:
+		for {
+			select {
+			case wc.mc <- f:
+				return nil
+			default:
+			}
+			select {
+			case <-wc.mc:
+				atomic.AddInt64(&wc.dropped, 1)
+			default:
+			}
+		}
+	case PolicyBlock:
+		t := time.NewTimer(opts.WriteTimeout)
+		defer t.Stop()
+		select {
+		case wc.mc <- f:
+			return nil
+		case <-wc.quit.Done():
+			return errClosed
+		case <-t.C:
+		}
+	}
+	dropped := atomic.AddInt64(&wc.dropped, 1)
+	if opts.WritePolicy == PolicyDisconnect && dropped >= int64(opts.MaxDrops) {
+		log.Warns("xws: close slow consumer", zap.Int64("conn", wc.id), zap.Int64("dropped", dropped))
+		wc.closeWith(ws.StatusPolicyViolation, "slow consumer")
+		wc.raw.Close() // the queued messages would block the close
+	}
+	return ErrWouldBlock
+}
+
+// Stats returns the stats of the write queue.
+func (wc *wrappedConn) Stats() ConnStats {
+	return ConnStats{
+		ID:       wc.id,
+		Queued:   len(wc.mc),
+		Capacity: cap(wc.mc),
+		Dropped:  atomic.LoadInt64(&wc.dropped),
+	}
+}
+
+// writeLoop writes the queued messages, the ones queued in a row are sent in
+// one write. Once the conn is closed, the queued messages are sent before
+// the close frame.
+func (wc *wrappedConn) writeLoop() {
+	defer close(wc.done)
+	for {
+		select {
+		case f := <-wc.mc:
+			if err := wc.writeFrames(f); err != nil {
+				log.Errors("xws: write error", zap.Int64("conn", wc.id), zap.Error(err))
+			}
+		case <-wc.quit.Done():
+			// the client may not read any more
+			wc.raw.SetWriteDeadline(time.Now().Add(closeTimeout))
+			select {
+			case f := <-wc.mc:
+				if err := wc.writeFrames(f); err != nil {
+					log.Errors("xws: write error", zap.Int64("conn", wc.id), zap.Error(err))
+				}
+			default:
+			}
+			wc.mu.Lock()
+			code, reason := wc.code, wc.reason
+			wc.mu.Unlock()
+			var body []byte
+			if !code.Empty() {
+				body = ws.NewCloseFrameBody(code, reason)
+			}
+			if err := wc.writeControl(ws.OpClose, body); err != nil && err != errClosed {
+				log.Infos("xws: write close error", zap.Int64("conn", wc.id), zap.Error(err))
+			}
+			// Serve returns once the client echoes the close frame
+			wc.raw.SetReadDeadline(time.Now().Add(closeTimeout))
+			log.Infos("xws write loop closed")
+			return
+		}
+	}
+}
+
+// writeFrames writes f and the frames queued behind it, and flushes them in
+// one write.
+func (wc *wrappedConn) writeFrames(f frame) error {
+	wc.wmu.Lock()
+	defer wc.wmu.Unlock()
+	for {
+		if err := wc.writeFrame(f); err != nil {
+			return err
+		}
+		select {
+		case f = <-wc.mc:
+			continue
+		default:
+		}
+		return wc.bw.Flush()
 	}
-	header := ws.Header{
-		Fin:    true,
-		OpCode: ws.OpBinary,
-		Length: int64(len(message)),
+}
+
+// writeFrame writes a final frame to bw, the frames of the server are not
+// masked. The deflate context follows the order of the frames written.
+func (wc *wrappedConn) writeFrame(f frame) error {
+	if !f.deflate {
+		return ws.WriteFrame(wc.bw, ws.NewFrame(f.op, true, f.payload))
 	}
-	err := ws.WriteHeader(wc.raw, header)
+	payload, err := wc.flate.deflate(f.payload)
 	if err != nil {
 		return err
 	}
-	_, err = wc.raw.Write(message)
-	return err
-	// return wsutil.WriteMessage(wc.raw, ws.StateServerSide, ws.OpBinary, message)
+	wf := ws.NewFrame(f.op, true, payload)
+	wf.Header.Rsv = ws.Rsv(true, false, false)
+	return ws.WriteFrame(wc.bw, wf)
+}
+
+// writeControl writes a control frame ahead of the queued messages, no frame
+// is written after the close frame.
+func (wc *wrappedConn) writeControl(op ws.OpCode, payload []byte) error {
+	wc.wmu.Lock()
+	defer wc.wmu.Unlock()
+	if wc.closeSent {
+		return errClosed
+	}
+	wc.closeSent = op == ws.OpClose
+	if err := ws.WriteFrame(wc.bw, ws.NewFrame(op, true, payload)); err != nil {
+		return err
+	}
+	return wc.bw.Flush()
 }
 
 // WriteMessage .
@@ -107,10 +314,24 @@ func (sc *wrappedConn) User() connection.User {
 	return sc.user
 }
 
-// Heartbeat .
+// Heartbeat extends the idle deadline of the conn.
 func (sc *wrappedConn) Heartbeat(ctx context.Context) (err error) {
-	// TODO
-	return
+	return sc.active()
+}
+
+// active extends the idle deadline of the conn if IdleTimeout is set, the
+// deadline to wait for the close frame of the client is kept.
+func (wc *wrappedConn) active() error {
+	d := wc.server.opts.IdleTimeout
+	if d <= 0 {
+		return nil
+	}
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	if wc.quit.HasFired() {
+		return nil
+	}
+	return wc.raw.SetReadDeadline(time.Now().Add(d))
 }
 
 // Auth .
@@ -124,6 +345,9 @@ func (sc *wrappedConn) Auth(ctx context.Context, user connection.User) (err erro
 		return
 	}
 	sc.user = user
+	if h := sc.server.opts.hub; h != nil {
+		h.Bind(sc)
+	}
 	return
 }
 
@@ -137,66 +361,155 @@ func (wc *wrappedConn) LocalAddr() net.Addr {
 	return wc.raw.LocalAddr()
 }
 
+// Serve reads the frames until the conn is closed. The pings are answered
+// with pongs, the fragmented messages are reassembled, and the close frames
+// are echoed. The protocol violations close the conn with the status codes
+// of RFC 6455.
 func (wc *wrappedConn) Serve(handle func(ctx context.Context, msg *message.Message)) {
+	max := wc.server.opts.MaxMessageSize
+	if max <= 0 {
+		max = message.MaxBytes
+	}
+	var (
+		state      = ws.StateServerSide
+		op         ws.OpCode // of the fragmented message
+		compressed bool      // of the fragmented message
+		fragments  []byte
+	)
+	if wc.flate != nil {
+		state = state.Set(ws.StateExtended)
+	}
+	wc.active()
 	for {
 		header, err := ws.ReadHeader(wc.raw)
 		if err != nil {
-			log.Errorw("xws: read header error, continue", "err", err)
+			if wc.quit.HasFired() { // closed by the server
+				return
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				log.Infos("xws: idle timeout", zap.Int64("conn", wc.id))
+				wc.closeWith(ws.StatusGoingAway, "idle timeout")
+				return
+			}
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				log.Errors("xws: read header error", zap.Int64("conn", wc.id), zap.Error(err))
+			}
 			return
-			// continue
 		}
+		wc.active()
 
-		// if wc.closed {
-		// 	log.Infos("xws: conn closed by server")
-		// 	return
-		// }
-
-		// select {
-		// case <-wc.ctx.Done():
-		// 	log.Infow("xws: conn closed on server side")
-		// 	return
-		// default:
-		// }
-
-		payload := make([]byte, header.Length)
-		_, err = io.ReadFull(wc.raw, payload)
-		if err != nil {
-			log.Errorw("xws: read payload error, continue", "err", err)
-			continue
+		if err = ws.CheckHeader(header, state); err != nil {
+			wc.fail(ws.StatusProtocolError, err)
+			return
+		}
+		// rsv1 is the compressed bit of permessage-deflate, which is set on
+		// the first frame of the messages only
+		if header.Rsv2() || header.Rsv3() || (header.Rsv1() && (header.OpCode.IsControl() || header.OpCode == ws.OpContinuation)) {
+			wc.fail(ws.StatusProtocolError, ws.ErrProtocolNonZeroRsv)
+			return
 		}
-		if header.Masked {
-			ws.Cipher(payload, header.Mask, 0)
+		if !header.OpCode.IsControl() {
+			if int64(len(fragments))+header.Length > int64(max) {
+				wc.fail(ws.StatusMessageTooBig, fmt.Errorf("message exceeds %d bytes", max))
+				return
+			}
 		}
 
-		// Reset the Masked flag, server frames must not be masked as
-		// RFC6455 says.
-		// header.Masked = false
-		// if err := ws.WriteHeader(conn, header); err != nil {
-		// 	// handle error
-		// 	panic(err)
-		// }
+		payload := make([]byte, header.Length)
+		if _, err = io.ReadFull(wc.raw, payload); err != nil {
+			log.Errors("xws: read payload error", zap.Int64("conn", wc.id), zap.Error(err))
+			return
+		}
+		ws.Cipher(payload, header.Mask, 0) // masked as checked
 
-		// log.Debug("opcode", header.OpCode)
+		switch header.OpCode {
+		case ws.OpPing:
+			if err = wc.writeControl(ws.OpPong, payload); err != nil && err != errClosed {
+				log.Errors("xws: write pong error", zap.Int64("conn", wc.id), zap.Error(err))
+				return
+			}
+			continue
+		case ws.OpPong: // the idle deadline is extended only
+			continue
+		case ws.OpClose:
+			wc.closeReceived(payload)
+			return
+		case ws.OpText, ws.OpBinary:
+			op, compressed = header.OpCode, header.Rsv1()
+		}
 
-		if header.OpCode == ws.OpClose {
-			log.Info("xws: opcode is close, close the conn")
+		if !header.Fin {
+			fragments = append(fragments, payload...)
+			state = state.Set(ws.StateFragmented)
+			continue
+		}
+		if state.Fragmented() {
+			payload, fragments = append(fragments, payload...), nil
+			state = state.Clear(ws.StateFragmented)
+		}
+		if compressed {
+			if payload, err = wc.flate.inflate(payload, max); err == errMessageTooBig {
+				wc.fail(ws.StatusMessageTooBig, err)
+				return
+			} else if err != nil {
+				wc.fail(ws.StatusInvalidFramePayloadData, err)
+				return
+			}
+		}
+		if op == ws.OpText && !utf8.Valid(payload) {
+			wc.fail(ws.StatusInvalidFramePayloadData, errors.New("invalid utf8 text"))
 			return
 		}
 
+		if payload, err = decodePayload(wc.comp, payload, max); err == encoding.ErrTooLarge {
+			wc.fail(ws.StatusMessageTooBig, err)
+			return
+		} else if err != nil {
+			wc.fail(ws.StatusInvalidFramePayloadData, err)
+			return
+		}
 		msg := new(message.Message)
-		// DEBUG
-		fmt.Println(string(payload))
 		if err = wc.codec.Unmarshal(payload, msg); err != nil {
-			log.Errors("xws: unmarshal message error", zap.Error(err))
-			continue
+			wc.fail(ws.StatusInvalidFramePayloadData, err)
+			return
 		}
 		// TODO sync.Pool
 		ctx := context.Background()
 		ctx = connection.NewContxet(ctx, wc)
 		// nmd := metadata.New(msg.Metadata)
 		nmd := message.DecodeMetadata(msg.Metas)
+		for k, v := range wc.md {
+			nmd[k] = v
+		}
+		nmd.Set(connection.RemoteAddrKey, wc.RemoteAddr().String())
 		ctx = metadata.NewIncomingContext(ctx, nmd)
 
 		handle(ctx, msg)
 	}
 }
+
+// closeReceived echoes the close frame of the client, a close frame without
+// code is echoed without code, and the invalid ones are protocol errors.
+func (wc *wrappedConn) closeReceived(payload []byte) {
+	if len(payload) == 0 {
+		wc.closeWith(0, "")
+		return
+	}
+	if len(payload) < 2 {
+		wc.fail(ws.StatusProtocolError, errors.New("truncated close code"))
+		return
+	}
+	code, reason := ws.ParseCloseFrameData(payload)
+	if err := ws.CheckCloseFrameData(code, reason); err != nil {
+		wc.fail(ws.StatusProtocolError, err)
+		return
+	}
+	log.Debugs("xws: closed by client", zap.Int64("conn", wc.id), zap.Uint16("code", uint16(code)), zap.String("reason", reason))
+	wc.closeWith(code, "")
+}
+
+// fail closes the conn with code because of err.
+func (wc *wrappedConn) fail(code ws.StatusCode, err error) {
+	log.Infos("xws: close conn", zap.Int64("conn", wc.id), zap.Uint16("code", uint16(code)), zap.Error(err))
+	wc.closeWith(code, err.Error())
+}
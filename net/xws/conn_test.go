@@ -0,0 +1,222 @@
+package xws
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/xsuners/mo/net/description"
+	"github.com/xsuners/mo/net/encoding"
+	"github.com/xsuners/mo/net/encoding/gzip"
+	"github.com/xsuners/mo/net/encoding/json"
+	mproto "github.com/xsuners/mo/net/encoding/proto"
+	"github.com/xsuners/mo/net/message"
+	"google.golang.org/protobuf/proto"
+)
+
+// dial connects a client speaking protobuf to s.
+func dial(t *testing.T, s *Server) (net.Conn, io.Reader) {
+	t.Helper()
+	d := ws.Dialer{Protocols: []string{"protobuf"}}
+	c, br, _, err := d.Dial(context.Background(), fmt.Sprintf("ws://127.0.0.1:%d/", s.Port()))
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	c.SetDeadline(time.Now().Add(2 * time.Second))
+	if br != nil {
+		return c, io.MultiReader(br, c)
+	}
+	return c, c
+}
+
+func send(t *testing.T, c net.Conn, op ws.OpCode, fin bool, payload []byte) {
+	t.Helper()
+	if err := ws.WriteFrame(c, ws.MaskFrame(ws.NewFrame(op, fin, payload))); err != nil {
+		t.Fatalf("WriteFrame() error = %v", err)
+	}
+}
+
+// expectClose reads the frames until a close frame, and checks its code.
+func expectClose(t *testing.T, r io.Reader, want ws.StatusCode) {
+	t.Helper()
+	for {
+		f, err := ws.ReadFrame(r)
+		if err != nil {
+			t.Fatalf("ReadFrame() error = %v, want close %d", err, want)
+		}
+		if f.Header.OpCode != ws.OpClose {
+			continue
+		}
+		if code, _ := ws.ParseCloseFrameData(f.Payload); code != want {
+			t.Errorf("close code = %d, want %d", code, want)
+		}
+		return
+	}
+}
+
+func TestControlFrames(t *testing.T) {
+	received := make(chan []byte, 1)
+	ds, stop := New(Port(0), MaxMessageSize(64),
+		UnknownServiceHandler(func(ctx context.Context, service, method string, data []byte, interceptor description.UnaryServerInterceptor) (interface{}, error) {
+			received <- data
+			return nil, nil
+		}),
+	)
+	defer stop()
+	s := ds.(*Server)
+	go s.Serve()
+	<-s.Ready()
+
+	c, r := dial(t, s)
+	send(t, c, ws.OpPing, true, []byte("hi"))
+	if f, err := ws.ReadFrame(r); err != nil || f.Header.OpCode != ws.OpPong || string(f.Payload) != "hi" {
+		t.Fatalf("ReadFrame() = %+v, %v, want pong hi", f.Header, err)
+	}
+
+	// a fragmented message interleaved with a ping
+	data, _ := proto.Marshal(&message.Message{Service: "echo", Data: []byte("hello")})
+	send(t, c, ws.OpBinary, false, data[:3])
+	send(t, c, ws.OpPing, true, nil)
+	send(t, c, ws.OpContinuation, false, data[3:5])
+	send(t, c, ws.OpContinuation, true, data[5:])
+	select {
+	case got := <-received:
+		if !bytes.Equal(got, []byte("hello")) {
+			t.Errorf("received = %q, want hello", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("fragmented message is not received")
+	}
+
+	send(t, c, ws.OpClose, true, ws.NewCloseFrameBody(ws.StatusNormalClosure, "bye"))
+	expectClose(t, r, ws.StatusNormalClosure)
+
+	for _, tt := range []struct {
+		name  string
+		frame ws.Frame
+		want  ws.StatusCode
+	}{
+		{"unmasked", ws.NewBinaryFrame([]byte("x")), ws.StatusProtocolError},
+		{"unexpected continuation", ws.MaskFrame(ws.NewFrame(ws.OpContinuation, true, []byte("x"))), ws.StatusProtocolError},
+		{"fragmented ping", ws.MaskFrame(ws.NewFrame(ws.OpPing, false, nil)), ws.StatusProtocolError},
+		{"invalid close code", ws.MaskFrame(ws.NewCloseFrame(ws.NewCloseFrameBody(999, ""))), ws.StatusProtocolError},
+		{"too big", ws.MaskFrame(ws.NewBinaryFrame(make([]byte, 65))), ws.StatusMessageTooBig},
+		{"invalid text", ws.MaskFrame(ws.NewTextFrame([]byte{0xff})), ws.StatusInvalidFramePayloadData},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c, r := dial(t, s)
+			if err := ws.WriteFrame(c, tt.frame); err != nil {
+				t.Fatalf("WriteFrame() error = %v", err)
+			}
+			expectClose(t, r, tt.want)
+		})
+	}
+}
+
+func TestBadPayload(t *testing.T) {
+	ds, stop := New(Port(0), MaxMessageSize(64))
+	defer stop()
+	s := ds.(*Server)
+	go s.Serve()
+	<-s.Ready()
+
+	big, _ := proto.Marshal(&message.Message{Service: "echo", Data: make([]byte, 1024)})
+	for _, tt := range []struct {
+		name    string
+		payload []byte
+		want    ws.StatusCode
+	}{
+		{"unknown flag", []byte{9, 1, 2}, ws.StatusInvalidFramePayloadData},
+		{"not compressed", append([]byte{flagCompressed}, "garbage"...), ws.StatusInvalidFramePayloadData},
+		{"not a message", encodePayload(encoding.GetCompressor(gzip.Name), 0, []byte{0xff, 0xff}), ws.StatusInvalidFramePayloadData},
+		{"decompressed too big", encodePayload(encoding.GetCompressor(gzip.Name), 0, big), ws.StatusMessageTooBig},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			d := ws.Dialer{Protocols: []string{"protobuf+gzip"}}
+			c, br, _, err := d.Dial(context.Background(), fmt.Sprintf("ws://127.0.0.1:%d/", s.Port()))
+			if err != nil {
+				t.Fatalf("Dial() error = %v", err)
+			}
+			defer c.Close()
+			c.SetDeadline(time.Now().Add(2 * time.Second))
+			var r io.Reader = c
+			if br != nil {
+				r = io.MultiReader(br, c)
+			}
+			send(t, c, ws.OpBinary, true, tt.payload)
+			expectClose(t, r, tt.want)
+		})
+	}
+}
+
+// newTestConn returns a conn of a server with opt, and the client side of it.
+func newTestConn(t *testing.T, codec string, opt ...Option) (*wrappedConn, net.Conn) {
+	t.Helper()
+	c, raw := net.Pipe()
+	t.Cleanup(func() { c.Close(); raw.Close() })
+	ds, _ := New(append(opt, BufferSize(2))...)
+	wc := newWrappedConn(1, ds.(*Server), raw)
+	wc.codec = encoding.GetCodec(codec)
+	return wc, c
+}
+
+func TestSlowConsumer(t *testing.T) {
+	for _, tt := range []struct {
+		policy  string
+		errs    []error
+		dropped int64
+		closed  bool
+	}{
+		{PolicyDropNew, []error{nil, nil, ErrWouldBlock, ErrWouldBlock}, 2, false},
+		{PolicyDropOldest, []error{nil, nil, nil, nil}, 2, false},
+		{PolicyBlock, []error{nil, nil, ErrWouldBlock, ErrWouldBlock}, 2, false},
+		{PolicyDisconnect, []error{nil, nil, ErrWouldBlock, ErrWouldBlock}, 2, true},
+	} {
+		wc, _ := newTestConn(t, mproto.Name, SlowConsumer(tt.policy, 10*time.Millisecond, 2))
+		for i, want := range tt.errs {
+			if err := wc.Write([]byte{byte(i)}); err != want {
+				t.Errorf("%s: Write(%d) error = %v, want %v", tt.policy, i, err, want)
+			}
+		}
+		st := wc.Stats()
+		if st.Queued != 2 || st.Capacity != 2 || st.Dropped != tt.dropped {
+			t.Errorf("%s: stats = %+v", tt.policy, st)
+		}
+		if closed := wc.quit.HasFired(); closed != tt.closed {
+			t.Errorf("%s: closed = %v, want %v", tt.policy, closed, tt.closed)
+		}
+		if tt.policy == PolicyDropOldest {
+			if first := <-wc.mc; first.payload[0] != 2 {
+				t.Errorf("%s: oldest queued = %v, want message 2", tt.policy, first.payload)
+			}
+		}
+	}
+}
+
+func TestWriteLoop(t *testing.T) {
+	wc, c := newTestConn(t, json.Name)
+	wc.Write([]byte(`{"a":1}`))
+	wc.Write([]byte(`{"a":2}`))
+	wc.Close()
+	if err := wc.Write([]byte(`{"a":3}`)); err != errClosed {
+		t.Errorf("Write() after Close error = %v", err)
+	}
+	go wc.writeLoop()
+
+	// the queued messages are sent before the close frame
+	c.SetDeadline(time.Now().Add(time.Second))
+	for _, want := range []string{`{"a":1}`, `{"a":2}`} {
+		f, err := ws.ReadFrame(c)
+		if err != nil || f.Header.OpCode != ws.OpText || string(f.Payload) != want {
+			t.Fatalf("ReadFrame() = %v %q, %v, want text %s", f.Header.OpCode, f.Payload, err, want)
+		}
+	}
+	expectClose(t, c, ws.StatusGoingAway)
+	<-wc.done
+}
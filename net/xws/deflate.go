@@ -0,0 +1,175 @@
+package xws
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/gobwas/httphead"
+)
+
+// flateName is the name of the permessage-deflate extension of RFC 7692.
+const flateName = "permessage-deflate"
+
+// The params of permessage-deflate.
+const (
+	serverNoContextTakeover = "server_no_context_takeover"
+	clientNoContextTakeover = "client_no_context_takeover"
+	serverMaxWindowBits     = "server_max_window_bits"
+	clientMaxWindowBits     = "client_max_window_bits"
+)
+
+// flateWindow is the window size of compress/flate, which is the max one of
+// permessage-deflate.
+const flateWindow = 1 << 15
+
+// flateTail completes a message ended by a sync flush with an empty final
+// block, so that the readers see io.EOF.
+var flateTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+var errMessageTooBig = errors.New("xws: inflated message is too big")
+
+// flateWriters pools the writers by level for the conns without context
+// takeover, as a writer takes hundreds of KB.
+var flateWriters [flate.BestCompression - flate.HuffmanOnly + 1]sync.Pool
+
+// flater deflates the messages sent and inflates the ones received by a
+// conn which negotiated permessage-deflate.
+type flater struct {
+	level     int
+	threshold int
+	// serverReset and clientReset are the negotiated no context takeover
+	// params, the compressor of the side is reset for every message.
+	serverReset bool
+	clientReset bool
+
+	w    *flate.Writer // nil if serverReset
+	wbuf bytes.Buffer
+
+	r      io.ReadCloser
+	window []byte // the latest inflated bytes, the dictionary of the next message
+}
+
+// negotiateFlate returns a flater and the reply of the first acceptable
+// permessage-deflate offer in header. The offers limiting the window of the
+// server are declined, as compress/flate always uses the max window.
+func negotiateFlate(header []byte, level, threshold int, noContextTakeover bool) (*flater, httphead.Option, bool) {
+	offers, ok := httphead.ParseOptions(header, nil)
+	if !ok {
+		return nil, httphead.Option{}, false
+	}
+	for _, offer := range offers {
+		if string(offer.Name) != flateName {
+			continue
+		}
+		f := &flater{level: level, threshold: threshold, serverReset: noContextTakeover}
+		valid := true
+		offer.Parameters.ForEach(func(k, v []byte) bool {
+			switch string(k) {
+			case serverNoContextTakeover:
+				f.serverReset = true
+			case clientNoContextTakeover:
+				f.clientReset = true
+			case serverMaxWindowBits:
+				valid = string(v) == "15"
+			case clientMaxWindowBits: // any window of the client is inflated
+				valid = len(v) == 0 || validWindowBits(v)
+			default:
+				valid = false
+			}
+			return valid
+		})
+		if !valid {
+			continue
+		}
+		reply := httphead.Option{Name: []byte(flateName)}
+		if f.serverReset {
+			reply.Parameters.Set([]byte(serverNoContextTakeover), nil)
+		}
+		if f.clientReset {
+			reply.Parameters.Set([]byte(clientNoContextTakeover), nil)
+		}
+		return f, reply, true
+	}
+	return nil, httphead.Option{}, false
+}
+
+func validWindowBits(v []byte) bool {
+	bits, ok := httphead.IntFromASCII(v)
+	return ok && bits >= 8 && bits <= 15
+}
+
+// deflate compresses data into a message without the tail of the sync
+// flush, which is valid until the next call. The level is checked by New.
+func (f *flater) deflate(data []byte) ([]byte, error) {
+	f.wbuf.Reset()
+	w := f.w
+	if f.serverReset {
+		pool := &flateWriters[f.level-flate.HuffmanOnly]
+		if pw, ok := pool.Get().(*flate.Writer); ok {
+			w = pw
+			w.Reset(&f.wbuf)
+		}
+		defer func() {
+			if w != nil {
+				pool.Put(w)
+			}
+		}()
+	}
+	if w == nil {
+		var err error
+		if w, err = flate.NewWriter(&f.wbuf, f.level); err != nil {
+			return nil, err
+		}
+		if !f.serverReset {
+			f.w = w
+		}
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	out := f.wbuf.Bytes()
+	return out[:len(out)-4], nil
+}
+
+// inflate decompresses a message of max bytes at most.
+func (f *flater) inflate(data []byte, max int) ([]byte, error) {
+	src := io.MultiReader(bytes.NewReader(data), bytes.NewReader(flateTail))
+	var dict []byte
+	if !f.clientReset {
+		dict = f.window
+	}
+	if f.r == nil {
+		f.r = flate.NewReaderDict(src, dict)
+	} else if err := f.r.(flate.Resetter).Reset(src, dict); err != nil {
+		return nil, err
+	}
+	out, err := io.ReadAll(io.LimitReader(f.r, int64(max)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > max {
+		return nil, errMessageTooBig
+	}
+	if !f.clientReset {
+		f.slide(out)
+	}
+	return out, nil
+}
+
+// slide appends data to the window, keeping the latest flateWindow bytes.
+func (f *flater) slide(data []byte) {
+	if len(data) >= flateWindow {
+		f.window = append(f.window[:0], data[len(data)-flateWindow:]...)
+		return
+	}
+	if n := len(f.window) + len(data) - flateWindow; n > 0 {
+		f.window = append(f.window[:0], f.window[n:]...)
+	}
+	f.window = append(f.window, data...)
+}
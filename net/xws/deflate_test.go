@@ -0,0 +1,125 @@
+package xws
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gobwas/httphead"
+	"github.com/gobwas/ws"
+	"github.com/xsuners/mo/net/description"
+	"github.com/xsuners/mo/net/message"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestNegotiateFlate(t *testing.T) {
+	for _, tt := range []struct {
+		header            string
+		noContextTakeover bool
+		reply             string // empty means declined
+	}{
+		{"permessage-deflate", false, "permessage-deflate"},
+		{"permessage-deflate; client_max_window_bits", false, "permessage-deflate"},
+		{"permessage-deflate; server_no_context_takeover; client_no_context_takeover", false,
+			"permessage-deflate;server_no_context_takeover;client_no_context_takeover"},
+		{"permessage-deflate", true, "permessage-deflate;server_no_context_takeover"},
+		{"permessage-deflate; server_max_window_bits=10, permessage-deflate; server_max_window_bits=15", false, "permessage-deflate"},
+		{"permessage-deflate; client_max_window_bits=20", false, ""},
+		{"permessage-deflate; unknown", false, ""},
+		{"x-webkit-deflate-frame", false, ""},
+	} {
+		_, reply, ok := negotiateFlate([]byte(tt.header), flate.BestSpeed, 0, tt.noContextTakeover)
+		var b bytes.Buffer
+		if ok {
+			httphead.WriteOptions(&b, []httphead.Option{reply})
+		}
+		if got := b.String(); got != tt.reply {
+			t.Errorf("negotiateFlate(%q) = %q, want %q", tt.header, got, tt.reply)
+		}
+	}
+}
+
+func TestFlater(t *testing.T) {
+	msgs := [][]byte{
+		bytes.Repeat([]byte(`{"json":"hello"}`), 100),
+		bytes.Repeat([]byte(`{"json":"hello"}`), 10), // referring to the first one
+		bytes.Repeat([]byte("x"), 2*flateWindow),
+	}
+	for _, reset := range []bool{false, true} {
+		server := &flater{level: flate.BestSpeed, serverReset: reset}
+		client := &flater{clientReset: reset}
+		var sizes []int
+		for i, msg := range msgs {
+			data, err := server.deflate(msg)
+			if err != nil {
+				t.Fatalf("reset %v: deflate(%d) error = %v", reset, i, err)
+			}
+			sizes = append(sizes, len(data))
+			out, err := client.inflate(data, len(msg))
+			if err != nil || !bytes.Equal(out, msg) {
+				t.Fatalf("reset %v: inflate(%d) = %d bytes, %v", reset, i, len(out), err)
+			}
+		}
+		if !reset && sizes[1] > 10 {
+			t.Errorf("message with context takeover deflated to %d bytes", sizes[1])
+		}
+		data, _ := server.deflate(msgs[0])
+		if _, err := client.inflate(data, len(msgs[0])-1); err != errMessageTooBig {
+			t.Errorf("reset %v: inflate() of a large message error = %v", reset, err)
+		}
+	}
+}
+
+func TestDeflateLevel(t *testing.T) {
+	defer func() {
+		if r := recover(); r != "xws: invalid deflate level 10" {
+			t.Errorf("panic = %v, want the invalid level", r)
+		}
+	}()
+	New(Deflate(flate.BestCompression+1, 0))
+}
+
+func TestDeflate(t *testing.T) {
+	received := make(chan string, 1)
+	ds, stop := New(Port(0), Deflate(flate.BestSpeed, 0),
+		UnknownServiceHandler(func(ctx context.Context, service, method string, data []byte, interceptor description.UnaryServerInterceptor) (interface{}, error) {
+			received <- string(data)
+			return nil, nil
+		}),
+	)
+	defer stop()
+	s := ds.(*Server)
+	go s.Serve()
+	<-s.Ready()
+
+	offer := httphead.NewOption(flateName, map[string]string{clientNoContextTakeover: ""})
+	d := ws.Dialer{Protocols: []string{"protobuf"}, Extensions: []httphead.Option{offer}}
+	c, _, hs, err := d.Dial(context.Background(), fmt.Sprintf("ws://127.0.0.1:%d/", s.Port()))
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer c.Close()
+	if len(hs.Extensions) != 1 || string(hs.Extensions[0].Name) != flateName {
+		t.Fatalf("extensions = %v, want %s", hs.Extensions, flateName)
+	}
+
+	client := &flater{level: flate.BestSpeed, serverReset: true}
+	data, _ := proto.Marshal(&message.Message{Service: "echo", Data: bytes.Repeat([]byte("hello"), 100)})
+	payload, _ := client.deflate(data)
+	f := ws.NewBinaryFrame(payload)
+	f.Header.Rsv = ws.Rsv(true, false, false)
+	if err = ws.WriteFrame(c, ws.MaskFrame(f)); err != nil {
+		t.Fatalf("WriteFrame() error = %v", err)
+	}
+	select {
+	case got := <-received:
+		if got != string(bytes.Repeat([]byte("hello"), 100)) {
+			t.Errorf("received %d bytes", len(got))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("deflated message is not received")
+	}
+}
@@ -1,28 +1,31 @@
 package xws
 
 import (
+	"bytes"
+	"compress/flate"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net"
-	"regexp"
+	"net/http"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/gobwas/httphead"
 	"github.com/gobwas/ws"
 	"github.com/xsuners/mo/log"
 	"github.com/xsuners/mo/misc/ip"
 	"github.com/xsuners/mo/misc/xrand"
 	"github.com/xsuners/mo/naming"
 	"github.com/xsuners/mo/net/connection"
+	"github.com/xsuners/mo/net/connection/hub"
 	"github.com/xsuners/mo/net/description"
-	"github.com/xsuners/mo/net/encoding"
-	"github.com/xsuners/mo/net/encoding/json"
 	"github.com/xsuners/mo/net/encoding/proto"
 	"github.com/xsuners/mo/net/message"
+	"github.com/xsuners/mo/net/proxyproto"
 	"github.com/xsuners/mo/sync/event"
 	"go.uber.org/zap"
 	"google.golang.org/grpc/status"
@@ -50,8 +53,10 @@ type Server struct {
 	cv      *sync.Cond     // signaled when connections close for GracefulStop
 	serveWG sync.WaitGroup // counts active Serve goroutines for GracefulStop
 
-	quit *event.Event
-	done *event.Event
+	quit  *event.Event
+	done  *event.Event
+	ready *event.Event
+	host  Host // set if mounted
 	// channelzRemoveOnce sync.Once
 
 	// channelzID int64 // channelz unique identification number
@@ -59,6 +64,8 @@ type Server struct {
 
 	serverWorkerChannels []chan *serverWorkerData
 
+	inflight int64 // numbers of requests in process
+
 	// ctx    context.Context
 	// cancel context.CancelFunc
 }
@@ -68,7 +75,29 @@ type Handler func(ctx context.Context, service, method string, data []byte, inte
 
 type Options struct {
 	NumServerWorkers uint32 `ini-name:"numServerWorkers" long:"ws-workers" description:"ws server workers number"`
-	Port             int
+	Port             int    `ini-name:"port" long:"ws-port" description:"ws port"`
+	Path             string `ini-name:"path" long:"ws-path" description:"ws path of the http server with the same name to serve on instead of listening on the port, e.g. /ws"`
+
+	Compressors       string `ini-name:"compressors" long:"ws-compressors" description:"ws compressors the clients can negotiate, comma separated"`
+	CompressThreshold int    `ini-name:"compressThreshold" long:"ws-compress-threshold" description:"ws min message bytes to compress"`
+
+	IdleTimeout time.Duration `ini-name:"idleTimeout" long:"ws-idle-timeout" description:"ws connections without inbound frames or heartbeats for it are closed, 0 means never"`
+
+	BufferSize   int           `ini-name:"bufferSize" long:"ws-buffer-size" description:"ws size of the write queue of a connection"`
+	WritePolicy  string        `ini-name:"writePolicy" long:"ws-write-policy" description:"ws policy when a write queue is full: dropNew, dropOldest, block or disconnect"`
+	WriteTimeout time.Duration `ini-name:"writeTimeout" long:"ws-write-timeout" description:"ws max time to wait for a full write queue with the block policy"`
+	MaxDrops     int           `ini-name:"maxDrops" long:"ws-max-drops" description:"ws drops before a connection is closed with the disconnect policy"`
+
+	MaxMessageSize int `ini-name:"maxMessageSize" long:"ws-max-message-size" description:"ws max bytes of a message, including all its fragments"`
+
+	Deflate                  bool `ini-name:"deflate" long:"ws-deflate" description:"ws negotiates the permessage-deflate extension with the clients"`
+	DeflateLevel             int  `ini-name:"deflateLevel" long:"ws-deflate-level" description:"ws permessage-deflate level, 1 to 9, -1 for the default"`
+	DeflateThreshold         int  `ini-name:"deflateThreshold" long:"ws-deflate-threshold" description:"ws min message bytes to deflate"`
+	DeflateNoContextTakeover bool `ini-name:"deflateNoContextTakeover" long:"ws-deflate-no-context-takeover" description:"ws deflates every message independently, which saves the memory of the conns"`
+
+	Origins string `ini-name:"origins" long:"ws-origins" description:"ws allowed origins of the browsers, comma separated, empty or * allows any"`
+
+	ProxyProtocol string `ini-name:"proxyProtocol" long:"ws-proxy-protocol" description:"ws trusted CIDRs of the load balancers sending PROXY protocol headers, comma separated, empty disables it"`
 
 	// creds                 credentials.TransportCredentials
 	// codec          Codec
@@ -96,6 +125,14 @@ type Options struct {
 	// maxHeaderListSize     *uint32
 	// headerTableSize       *uint32
 	unknownServiceHandler Handler
+	upgradeAuth           UpgradeAuthFunc
+	hub                   *hub.Hub
+}
+
+// Host is a server which xws can be mounted on, e.g. a xhttp server.
+type Host interface {
+	description.Readier
+	description.Porter
 }
 
 var defaultOptions = Options{
@@ -104,11 +141,35 @@ var defaultOptions = Options{
 	connectionTimeout: 120 * time.Second,
 	NumServerWorkers:  100,
 	Port:              5000,
+	Compressors:       "gzip,snappy",
+	CompressThreshold: 1024,
+	MaxMessageSize:    message.MaxBytes,
+	BufferSize:        256,
+	WritePolicy:       PolicyDropNew,
+	WriteTimeout:      time.Second,
+	MaxDrops:          100,
+	DeflateLevel:      flate.BestSpeed,
+	DeflateThreshold:  1024,
 	// codec:             NewBaseCodec(),
 	// writeBufferSize:       defaultWriteBufSize,
 	// readBufferSize:        defaultReadBufSize,
 }
 
+// The policies applied when the write queue of a conn is full.
+const (
+	// PolicyDropNew drops the message written, Write fails with
+	// ErrWouldBlock.
+	PolicyDropNew = "dropNew"
+	// PolicyDropOldest drops the oldest message queued to make room.
+	PolicyDropOldest = "dropOldest"
+	// PolicyBlock waits WriteTimeout for room, then drops the message
+	// written like PolicyDropNew.
+	PolicyBlock = "block"
+	// PolicyDisconnect drops the message written like PolicyDropNew, and
+	// closes the conn with 1008 once MaxDrops are dropped.
+	PolicyDisconnect = "disconnect"
+)
+
 // A Option sets options such as credentials, codec and keepalive parameters, etc.
 type Option interface {
 	apply(*Options)
@@ -229,6 +290,117 @@ func Port(port int) Option {
 	})
 }
 
+// Path returns a Option that serves the conns on the path of a Host,
+// see Mount, instead of listening on the port.
+func Path(path string) Option {
+	return newFuncOption(func(o *Options) {
+		o.Path = path
+	})
+}
+
+// Hub returns a Option that keeps the connections in h.
+func Hub(h *hub.Hub) Option {
+	return newFuncOption(func(o *Options) {
+		o.hub = h
+	})
+}
+
+// IdleTimeout returns a Option that closes the connections without inbound
+// frames or heartbeats for d, 0 means never.
+func IdleTimeout(d time.Duration) Option {
+	return newFuncOption(func(o *Options) {
+		o.IdleTimeout = d
+	})
+}
+
+// BufferSize returns a Option that sets the size of the write queue of the
+// conns.
+func BufferSize(n int) Option {
+	return newFuncOption(func(o *Options) {
+		o.BufferSize = n
+	})
+}
+
+// SlowConsumer returns a Option that sets the policy applied when the write
+// queue of a conn is full, timeout is used by PolicyBlock and maxDrops by
+// PolicyDisconnect.
+func SlowConsumer(policy string, timeout time.Duration, maxDrops int) Option {
+	return newFuncOption(func(o *Options) {
+		o.WritePolicy = policy
+		o.WriteTimeout = timeout
+		o.MaxDrops = maxDrops
+	})
+}
+
+// MaxMessageSize returns a Option that sets the max bytes of a message, the
+// conns sending larger ones, fragmented or not, are closed with 1009.
+func MaxMessageSize(n int) Option {
+	return newFuncOption(func(o *Options) {
+		o.MaxMessageSize = n
+	})
+}
+
+// Deflate returns a Option that negotiates permessage-deflate with the
+// clients, the messages of threshold bytes at least are deflated at level.
+func Deflate(level, threshold int) Option {
+	return newFuncOption(func(o *Options) {
+		o.Deflate = true
+		o.DeflateLevel = level
+		o.DeflateThreshold = threshold
+	})
+}
+
+// DeflateNoContextTakeover returns a Option that deflates every message
+// independently, so that the compressors are shared by the conns instead of
+// kept by each of them, at the cost of the ratio.
+func DeflateNoContextTakeover() Option {
+	return newFuncOption(func(o *Options) {
+		o.DeflateNoContextTakeover = true
+	})
+}
+
+// UpgradeAuth returns a Option that sets f to check the upgrade requests.
+func UpgradeAuth(f UpgradeAuthFunc) Option {
+	return newFuncOption(func(o *Options) {
+		o.upgradeAuth = f
+	})
+}
+
+// Origins returns a Option that refuses the browsers of the other origins,
+// "*" allows any.
+func Origins(origins ...string) Option {
+	return newFuncOption(func(o *Options) {
+		o.Origins = strings.Join(origins, ",")
+	})
+}
+
+// ProxyProtocol returns a Option that parses the PROXY protocol headers
+// sent by the load balancers in the CIDRs, so that the conns report the
+// addresses of the clients.
+func ProxyProtocol(cidrs ...string) Option {
+	return newFuncOption(func(o *Options) {
+		o.ProxyProtocol = strings.Join(cidrs, ",")
+	})
+}
+
+// Compressors returns a Option that sets the compressors the clients can
+// negotiate, none means compression is disabled.
+func Compressors(names ...string) Option {
+	return newFuncOption(func(o *Options) {
+		o.Compressors = strings.Join(names, ",")
+	})
+}
+
+// CompressThreshold returns a Option that sets the min size of the messages
+// to compress, the smaller ones are sent as they are.
+func CompressThreshold(n int) Option {
+	return newFuncOption(func(o *Options) {
+		o.CompressThreshold = n
+	})
+}
+
+const drainPollInterval = 10 * time.Millisecond
+
 // serverWorkerResetThreshold defines how often the stack must be reset. Every
 // N requests, by spawning a new goroutine in its place, a worker can reset its
 // stack so that large stacks don't live in memory forever. 2^16 should allow
@@ -252,6 +424,7 @@ func (s *Server) serverWorker(ch chan *serverWorkerData) {
 			return
 		}
 		s.process(data.ctx, data.conn, data.data)
+		atomic.AddInt64(&s.inflight, -1)
 		data.wg.Done()
 	}
 	go s.serverWorker(ch)
@@ -280,6 +453,9 @@ func New(opt ...Option) (description.Server, func()) {
 	for _, o := range opt {
 		o.apply(&opts)
 	}
+	if opts.Deflate && (opts.DeflateLevel < flate.HuffmanOnly || opts.DeflateLevel > flate.BestCompression) {
+		panic(fmt.Sprintf("xws: invalid deflate level %d", opts.DeflateLevel))
+	}
 	s := &Server{
 		lis:      make(map[net.Listener]bool),
 		opts:     opts,
@@ -287,11 +463,13 @@ func New(opt ...Option) (description.Server, func()) {
 		services: make(map[string]*description.ServiceInfo),
 		quit:     event.NewEvent(),
 		done:     event.NewEvent(),
+		ready:    event.NewEvent(),
 		// czData:   new(channelzData),
 	}
 
-	// TODO
-	chainUnaryServerInterceptors(s)
+	// Prepend opts.unaryInt to the chaining interceptors if it exists, since unaryInt will
+	// be executed before any other chained interceptors.
+	s.opts.unaryInt = description.ChainUnaryServer(append([]description.UnaryServerInterceptor{s.opts.unaryInt}, s.opts.chainUnaryInts...)...)
 	// chainStreamServerInterceptors(s)
 
 	s.cv = sync.NewCond(&s.mu)
@@ -326,40 +504,6 @@ func New(opt ...Option) (description.Server, func()) {
 
 var _ description.ServiceRegistrar = (*Server)(nil)
 
-// chainUnaryServerInterceptors chains all unary server interceptors into one.
-func chainUnaryServerInterceptors(s *Server) {
-	// Prepend opts.unaryInt to the chaining interceptors if it exists, since unaryInt will
-	// be executed before any other chained interceptors.
-	interceptors := s.opts.chainUnaryInts
-	if s.opts.unaryInt != nil {
-		interceptors = append([]description.UnaryServerInterceptor{s.opts.unaryInt}, s.opts.chainUnaryInts...)
-	}
-
-	var chainedInt description.UnaryServerInterceptor
-	if len(interceptors) == 0 {
-		chainedInt = nil
-	} else if len(interceptors) == 1 {
-		chainedInt = interceptors[0]
-	} else {
-		chainedInt = func(ctx context.Context, req interface{}, info *description.UnaryServerInfo, handler description.UnaryHandler) (interface{}, error) {
-			return interceptors[0](ctx, req, info, getChainUnaryHandler(interceptors, 0, info, handler))
-		}
-	}
-
-	s.opts.unaryInt = chainedInt
-}
-
-// getChainUnaryHandler recursively generate the chained UnaryHandler
-func getChainUnaryHandler(interceptors []description.UnaryServerInterceptor, curr int, info *description.UnaryServerInfo, finalHandler description.UnaryHandler) description.UnaryHandler {
-	if curr == len(interceptors)-1 {
-		return finalHandler
-	}
-
-	return func(ctx context.Context, req interface{}) (interface{}, error) {
-		return interceptors[curr+1](ctx, req, info, getChainUnaryHandler(interceptors, curr+1, info, finalHandler))
-	}
-}
-
 // RegisterService .
 func (s *Server) RegisterService(sd *description.ServiceDesc, ss interface{}) {
 	s.mu.Lock()
@@ -395,10 +539,17 @@ func (s *Server) Register(ss interface{}, sds ...*description.ServiceDesc) {
 // this method returns.
 // Serve will return a non-nil error unless Stop or GracefulStop is called.
 func (s *Server) Serve() error {
+	if s.opts.Path != "" {
+		return s.serveMounted()
+	}
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", s.opts.Port))
 	if err != nil {
 		return err
 	}
+	if lis, err = proxyproto.Wrap(lis, s.opts.ProxyProtocol); err != nil {
+		lis.Close()
+		return err
+	}
 	s.mu.Lock()
 	// s.printf("serving")
 	s.serve = true
@@ -420,11 +571,13 @@ func (s *Server) Serve() error {
 
 	// ls := &listenSocket{Listener: lis}
 	s.lis[lis] = true
+	s.opts.Port = lis.Addr().(*net.TCPAddr).Port
 
 	// if channelz.IsOn() {
 	// 	ls.channelzID = channelz.RegisterListenSocket(ls, s.channelzID, lis.Addr().String())
 	// }
 	s.mu.Unlock()
+	s.ready.Fire()
 
 	defer func() {
 		s.mu.Lock()
@@ -488,6 +641,96 @@ func (s *Server) Serve() error {
 	}
 }
 
+// Mount sets the host serving the requests of Path by s.ServeHTTP, s is ready
+// once it is, and takes its port.
+func (s *Server) Mount(h Host) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.host = h
+}
+
+// serveMounted waits for the host to be ready, and then until s is stopped.
+func (s *Server) serveMounted() error {
+	s.mu.Lock()
+	s.serve = true
+	if s.lis == nil {
+		s.mu.Unlock()
+		return errors.New("xws: the server has been stopped")
+	}
+	host := s.host
+	s.mu.Unlock()
+	if host == nil {
+		return fmt.Errorf("xws: no server to mount %s on", s.opts.Path)
+	}
+
+	select {
+	case <-host.Ready():
+	case <-s.quit.Done():
+		<-s.done.Done()
+		return nil
+	}
+	s.mu.Lock()
+	s.opts.Port = host.Port()
+	s.mu.Unlock()
+	s.ready.Fire()
+	log.Infos("xws: mounted", zap.String("path", s.opts.Path), zap.Int("port", host.Port()))
+
+	<-s.quit.Done()
+	<-s.done.Done()
+	return nil
+}
+
+// ServeHTTP hijacks the conn of an upgrade request and serves it, so that s
+// can be mounted on a HTTP server.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	if s.lis == nil { // stopped or draining
+		s.mu.Unlock()
+		http.Error(w, "xws: the server is stopped", http.StatusServiceUnavailable)
+		return
+	}
+	// counted like the accepted conns, so that Stop waits for it
+	s.serveWG.Add(1)
+	s.mu.Unlock()
+	defer s.serveWG.Done()
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "xws: the conn can not be hijacked", http.StatusInternalServerError)
+		return
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		log.Errors("xws: hijack error", zap.Error(err))
+		return
+	}
+	// clear the deadlines set by the HTTP server
+	conn.SetDeadline(time.Time{})
+	if brw.Reader.Buffered() > 0 { // frames sent right after the request
+		conn = &hijackedConn{Conn: conn, r: brw.Reader}
+	}
+
+	// replay the request parsed by the HTTP server to the upgrader
+	var req bytes.Buffer
+	fmt.Fprintf(&req, "%s %s %s\r\nHost: %s\r\n", r.Method, r.RequestURI, r.Proto, r.Host)
+	r.Header.Write(&req)
+	req.WriteString("\r\n")
+	s.upgrade(conn, struct {
+		io.Reader
+		io.Writer
+	}{&req, conn})
+}
+
+// hijackedConn reads the bytes buffered by the HTTP server first.
+type hijackedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *hijackedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
 // handleConn forks a goroutine to handle a just-accepted connection that
 // has not had any I/O performed on it yet.
 func (s *Server) handleConn(conn net.Conn) {
@@ -495,41 +738,63 @@ func (s *Server) handleConn(conn net.Conn) {
 		conn.Close()
 		return
 	}
+	s.upgrade(conn, conn)
+}
 
+// upgrade reads the upgrade request of conn from rw and writes the response
+// to it, then serves conn if it is upgraded.
+func (s *Server) upgrade(conn net.Conn, rw io.ReadWriter) {
 	wc := newWrappedConn(connection.GenID(), s, conn)
+	allowed := splitList(s.opts.Compressors)
 
+	r := &UpgradeRequest{Header: http.Header{}, RemoteAddr: conn.RemoteAddr()}
+	var rejected error
 	u := ws.Upgrader{
-		OnHeader: func(key, value []byte) (err error) {
-			log.Infof("xws: non-websocket header: %q=%q", key, value)
-			return
+		OnRequest: func(uri []byte) error {
+			r.URI = string(uri)
+			return nil
 		},
-		ProtocolCustom: func(b []byte) (string, bool) {
-			ok, err := regexp.Match("proto", b)
-			if err != nil {
-				log.Errors("xws: protocol error", zap.Error(err))
-				return "", false
-			}
-			if ok {
-				wc.codec = encoding.GetCodec(proto.Name)
-				return "protobuf", true
+		OnHost: func(host []byte) error {
+			r.Host = string(host)
+			return nil
+		},
+		OnHeader: func(key, value []byte) error {
+			r.Header.Add(string(key), string(value))
+			return nil
+		},
+		OnBeforeUpgrade: func() (ws.HandshakeHeader, error) {
+			if rejected = s.checkUpgrade(wc, r); rejected != nil {
+				return nil, rejection(rejected)
 			}
-			ok, err = regexp.Match("json", b)
-			if err != nil {
-				log.Errors("xws: protocol error", zap.Error(err))
-				return "", false
+			return ws.HandshakeHeaderString(""), nil
+		},
+		ProtocolCustom: func(b []byte) (string, bool) {
+			var protocol string
+			protocol, wc.codec, wc.comp = selectProtocol(b, allowed)
+			return protocol, wc.codec != nil
+		},
+	}
+	if s.opts.Deflate {
+		u.ExtensionCustom = func(b []byte, selected []httphead.Option) ([]httphead.Option, bool) {
+			if wc.flate != nil { // negotiated by a previous header
+				return selected, true
 			}
+			f, reply, ok := negotiateFlate(b, s.opts.DeflateLevel, s.opts.DeflateThreshold, s.opts.DeflateNoContextTakeover)
 			if ok {
-				wc.codec = encoding.GetCodec(json.Name)
-				return "json", true
+				wc.flate = f
+				selected = append(selected, reply)
 			}
-			return "", false
-		},
+			return selected, true
+		}
 	}
-	_, err := u.Upgrade(conn)
+	_, err := u.Upgrade(rw)
 	if err != nil {
-		if err == io.EOF {
+		switch {
+		case err == io.EOF:
 			log.Infos("check")
-		} else {
+		case rejected != nil:
+			log.Infos("xws: upgrade rejected", zap.String("uri", r.URI), zap.Stringer("remote", r.RemoteAddr), zap.Error(rejected))
+		default:
 			log.Errors("xws: upgrade error", zap.Error(err))
 		}
 		conn.Close()
@@ -545,6 +810,22 @@ func (s *Server) handleConn(conn net.Conn) {
 	}()
 }
 
+// checkUpgrade checks the origin and authenticates r by UpgradeAuth.
+func (s *Server) checkUpgrade(wc *wrappedConn, r *UpgradeRequest) error {
+	if err := checkOrigin(s.opts.Origins, r); err != nil {
+		return err
+	}
+	if s.opts.upgradeAuth == nil {
+		return nil
+	}
+	user, md, err := s.opts.upgradeAuth(connection.NewContxet(context.Background(), wc), r)
+	if err != nil {
+		return err
+	}
+	wc.user, wc.md = user, md
+	return nil
+}
+
 func (s *Server) addConn(conn *wrappedConn) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -571,8 +852,13 @@ func (s *Server) removeConn(conn *wrappedConn) {
 }
 
 func (s *Server) serveStreams(conn *wrappedConn) {
+	go conn.writeLoop()
 	// must serve and process all over then to close
-	defer conn.Close()
+	defer func() {
+		conn.Close()
+		<-conn.done
+		conn.raw.Close()
+	}()
 	// TODO 精细化关闭
 	// defer func() {
 	// // make client side gracefal close
@@ -589,6 +875,12 @@ func (s *Server) serveStreams(conn *wrappedConn) {
 	var wg sync.WaitGroup
 	var roundRobinCounter uint32
 
+	if h := s.opts.hub; h != nil {
+		h.Add(conn)
+		h.Bind(conn) // authed by UpgradeAuth
+		defer h.Remove(conn)
+	}
+
 	// on conn created
 	if cb := s.opts.connectHandler; cb != nil {
 		cb(conn)
@@ -596,9 +888,11 @@ func (s *Server) serveStreams(conn *wrappedConn) {
 
 	conn.Serve(func(ctx context.Context, msg *message.Message) {
 		wg.Add(1)
+		atomic.AddInt64(&s.inflight, 1)
 		if s.opts.NumServerWorkers < 1 {
 			go func() {
 				defer wg.Done()
+				defer atomic.AddInt64(&s.inflight, -1)
 				s.process(ctx, conn, msg)
 			}()
 			return
@@ -611,6 +905,7 @@ func (s *Server) serveStreams(conn *wrappedConn) {
 		default: // If all msg workers are busy, fallback to the default code path.
 			go func() {
 				s.process(ctx, conn, msg)
+				atomic.AddInt64(&s.inflight, -1)
 				wg.Done()
 			}()
 		}
@@ -765,6 +1060,79 @@ func (s *Server) Naming(nm naming.Naming) error {
 	return nil
 }
 
+// Services .
+func (s *Server) Services() map[string]*description.ServiceInfo {
+	return s.services
+}
+
+// Stats returns the write queue stats of the conns.
+func (s *Server) Stats() []ConnStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := make([]ConnStats, 0, len(s.conns))
+	for c := range s.conns {
+		stats = append(stats, c.Stats())
+	}
+	return stats
+}
+
+// Path returns the path s is mounted on, empty if it listens on the port.
+func (s *Server) Path() string {
+	return s.opts.Path
+}
+
+// Port .
+func (s *Server) Port() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.opts.Port
+}
+
+// Ready .
+func (s *Server) Ready() <-chan struct{} {
+	return s.ready.Done()
+}
+
+// Drain stops the server from accepting new connections and sends GOAWAY
+// to the clients, it then blocks until the requests in process are finished
+// or ctx is done, and closes the connections.
+func (s *Server) Drain(ctx context.Context) error {
+	s.quit.Fire()
+	s.mu.Lock()
+	for lis := range s.lis {
+		lis.Close()
+	}
+	s.lis = nil
+	conns := make([]*wrappedConn, 0, len(s.conns))
+	for conn := range s.conns {
+		conns = append(conns, conn)
+	}
+	s.mu.Unlock()
+
+	for _, conn := range conns {
+		if err := conn.WriteMessage(message.GoAway("xws: server is draining")); err != nil {
+			log.Warns("xws: write goaway error", zap.Error(err))
+		}
+	}
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for atomic.LoadInt64(&s.inflight) > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	return nil
+}
+
 // Stop stops the gRPC server gracefully. It stops the server from
 // accepting new connections and RPCs and blocks until all the pending RPCs are
 // finished.
@@ -0,0 +1,95 @@
+package xws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gobwas/ws"
+	"github.com/xsuners/mo/misc/uhttp"
+	"github.com/xsuners/mo/net/connection"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// UpgradeRequest is the HTTP request upgrading a conn to websocket.
+type UpgradeRequest struct {
+	URI        string      // e.g. "/ws?token=x"
+	Host       string      // the Host header
+	Header     http.Header // the headers except the websocket ones
+	RemoteAddr net.Addr
+}
+
+// Path returns the path of the URI.
+func (r *UpgradeRequest) Path() string {
+	if u, err := url.ParseRequestURI(r.URI); err == nil {
+		return u.Path
+	}
+	return ""
+}
+
+// Query returns the parsed query string of the URI.
+func (r *UpgradeRequest) Query() url.Values {
+	if u, err := url.ParseRequestURI(r.URI); err == nil {
+		return u.Query()
+	}
+	return url.Values{}
+}
+
+// UpgradeAuthFunc checks the upgrade request of a conn before it is
+// accepted. The upgrade is refused with the HTTP status of the error
+// returned, which is made by Reject or mapped from its grpc code,
+// Unauthorized if it has neither. The user returned, if any, is set to the
+// conn as if by Auth, and md is added to the incoming metadata of all the
+// messages of the conn.
+type UpgradeAuthFunc func(ctx context.Context, r *UpgradeRequest) (user connection.User, md metadata.MD, err error)
+
+// rejectError refuses an upgrade with an HTTP status.
+type rejectError struct {
+	code   int
+	reason string
+}
+
+func (e *rejectError) Error() string {
+	return e.reason
+}
+
+// Reject returns an error refusing the upgrade with the HTTP status code.
+func Reject(code int, reason string) error {
+	return &rejectError{code: code, reason: reason}
+}
+
+// rejection converts err into the error refusing the upgrade.
+func rejection(err error) error {
+	code, reason := http.StatusUnauthorized, err.Error()
+	var re *rejectError
+	if errors.As(err, &re) {
+		code = re.code
+	} else if st, ok := status.FromError(err); ok {
+		code, reason = uhttp.Code2Status(st.Code()), st.Message()
+	}
+	return ws.RejectConnectionError(ws.RejectionStatus(code), ws.RejectionReason(reason))
+}
+
+// checkOrigin refuses the browsers of the origins not in the comma separated
+// list, the clients without origin and the empty list or "*" allow any.
+func checkOrigin(origins string, r *UpgradeRequest) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	allowed := splitList(origins)
+	if len(allowed) == 0 {
+		return nil
+	}
+	for _, o := range allowed {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return nil
+		}
+	}
+	return Reject(http.StatusForbidden, fmt.Sprintf("xws: origin %s is not allowed", origin))
+}
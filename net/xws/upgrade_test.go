@@ -0,0 +1,138 @@
+package xws
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/xsuners/mo/net/connection"
+	"github.com/xsuners/mo/net/description"
+	"github.com/xsuners/mo/net/message"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+type tokenUser string
+
+func (tokenUser) Disconnected() {}
+
+func TestUpgradeAuth(t *testing.T) {
+	type call struct {
+		user   connection.User
+		tenant []string
+	}
+	calls := make(chan call, 1)
+	ds, stop := New(Port(0), Origins("https://example.com"),
+		UpgradeAuth(func(ctx context.Context, r *UpgradeRequest) (connection.User, metadata.MD, error) {
+			switch token := r.Query().Get("token"); token {
+			case "":
+				return nil, nil, status.Error(codes.Unauthenticated, "token is missing")
+			case "banned":
+				return nil, nil, Reject(http.StatusTooManyRequests, "slow down")
+			default:
+				return tokenUser(token), metadata.Pairs("tenant", r.Header.Get("X-Tenant")), nil
+			}
+		}),
+		UnknownServiceHandler(func(ctx context.Context, service, method string, data []byte, interceptor description.UnaryServerInterceptor) (interface{}, error) {
+			conn, _ := connection.FromContext(ctx)
+			md, _ := metadata.FromIncomingContext(ctx)
+			calls <- call{conn.User(), md.Get("tenant")}
+			return nil, nil
+		}),
+	)
+	defer stop()
+	s := ds.(*Server)
+	go s.Serve()
+	<-s.Ready()
+
+	for _, tt := range []struct {
+		uri    string
+		origin string
+		status int // 0 means upgraded
+	}{
+		{"/ws", "", http.StatusUnauthorized},
+		{"/ws?token=banned", "", http.StatusTooManyRequests},
+		{"/ws?token=alice", "https://evil.com", http.StatusForbidden},
+		{"/ws?token=alice", "https://example.com", 0},
+	} {
+		header := http.Header{"X-Tenant": []string{"acme"}}
+		if tt.origin != "" {
+			header.Set("Origin", tt.origin)
+		}
+		d := ws.Dialer{Protocols: []string{"protobuf"}, Header: ws.HandshakeHeaderHTTP(header)}
+		c, _, _, err := d.Dial(context.Background(), fmt.Sprintf("ws://127.0.0.1:%d%s", s.Port(), tt.uri))
+		if tt.status != 0 {
+			if err != ws.StatusError(tt.status) {
+				t.Errorf("Dial(%s, %q) error = %v, want %d", tt.uri, tt.origin, err, tt.status)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Dial(%s) error = %v", tt.uri, err)
+		}
+		defer c.Close()
+		data, _ := proto.Marshal(&message.Message{Service: "echo"})
+		send(t, c, ws.OpBinary, true, data)
+		select {
+		case got := <-calls:
+			if got.user != tokenUser("alice") || len(got.tenant) != 1 || got.tenant[0] != "acme" {
+				t.Errorf("call = %+v, want alice of acme", got)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("message is not handled")
+		}
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	received := make(chan string, 1)
+	ds, stop := New(Origins("https://example.com"),
+		UnknownServiceHandler(func(ctx context.Context, service, method string, data []byte, interceptor description.UnaryServerInterceptor) (interface{}, error) {
+			received <- string(data)
+			return nil, nil
+		}),
+	)
+	s := ds.(*Server)
+	hs := httptest.NewServer(s)
+	defer hs.Close()
+	url := "ws" + strings.TrimPrefix(hs.URL, "http")
+
+	d := ws.Dialer{Protocols: []string{"protobuf"}, Header: ws.HandshakeHeaderHTTP(http.Header{"Origin": []string{"https://evil.com"}})}
+	if _, _, _, err := d.Dial(context.Background(), url); err != ws.StatusError(http.StatusForbidden) {
+		t.Errorf("Dial() from evil.com error = %v, want 403", err)
+	}
+
+	d = ws.Dialer{Protocols: []string{"protobuf"}}
+	c, _, _, err := d.Dial(context.Background(), url)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer c.Close()
+	data, _ := proto.Marshal(&message.Message{Service: "echo", Data: []byte("hello")})
+	send(t, c, ws.OpBinary, true, data)
+	select {
+	case got := <-received:
+		if got != "hello" {
+			t.Errorf("received = %q, want hello", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message is not handled")
+	}
+
+	stop()
+	resp, err := http.Get(hs.URL)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("status after stop = %d, want 503", resp.StatusCode)
+	}
+}
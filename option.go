@@ -1,12 +1,15 @@
 package mo
 
 import (
+	"time"
+
 	"github.com/xsuners/mo/log"
 	"github.com/xsuners/mo/naming"
 	"github.com/xsuners/mo/net/description"
 	"github.com/xsuners/mo/net/xcron"
 	"github.com/xsuners/mo/net/xgrpc"
 	"github.com/xsuners/mo/net/xhttp"
+	"github.com/xsuners/mo/net/xlocal"
 	"github.com/xsuners/mo/net/xnats"
 	"github.com/xsuners/mo/net/xtcp"
 	"github.com/xsuners/mo/net/xws"
@@ -31,174 +34,291 @@ func Naming(n naming.Naming) Option {
 	}
 }
 
-func WSSDS(svc interface{}, sds ...*description.ServiceDesc) Option {
+// Interceptors installs the unary server interceptors on every server of the
+// app. They are chained before the interceptors added by the transport's own
+// ChainUnaryInterceptor option.
+func Interceptors(ints ...description.UnaryServerInterceptor) Option {
 	return func(a *app) {
-		for _, s := range a.servers {
-			if _, ok := s.server.(*xws.Server); ok {
-				s.ssds = append(s.ssds, &ssd{
-					svc: svc,
-					sds: sds,
-				})
-				return
-			}
-		}
-		panic("xws not exist")
+		a.ints = append(a.ints, ints...)
 	}
 }
 
-func TCPSDS(svc interface{}, sds ...*description.ServiceDesc) Option {
+// Reloader registers callbacks which are called with the new options when
+// the config is reloaded on SIGHUP, an error of them is logged and keeps the
+// old config. They are called in order before the builtin options are
+// applied, so they should check the new options before applying any.
+func Reloader(fns ...ReloadFunc) Option {
 	return func(a *app) {
-		for _, s := range a.servers {
-			if _, ok := s.server.(*xtcp.Server); ok {
-				s.ssds = append(s.ssds, &ssd{
-					svc: svc,
-					sds: sds,
-				})
-				return
-			}
-		}
-		panic("xtcp not exist")
+		a.reloaders = append(a.reloaders, fns...)
 	}
 }
 
-func GRPCSDS(svc interface{}, sds ...*description.ServiceDesc) Option {
+// Admin serves health, readiness, build info, service listing and pprof
+// endpoints on the given port.
+func Admin(port int) Option {
 	return func(a *app) {
-		for _, s := range a.servers {
-			if _, ok := s.server.(*xgrpc.Server); ok {
-				s.ssds = append(s.ssds, &ssd{
-					svc: svc,
-					sds: sds,
-				})
-				return
-			}
-		}
-		panic("xgrpc not exist")
+		a.admin = newAdmin(a, port)
 	}
 }
 
-func HTTPSDS(svc interface{}, sds ...*description.ServiceDesc) Option {
+// ReadyTimeout sets how long the app waits for the servers to be ready
+// before registering them to naming.
+func ReadyTimeout(d time.Duration) Option {
 	return func(a *app) {
-		for _, s := range a.servers {
-			if _, ok := s.server.(*xhttp.Server); ok {
-				s.ssds = append(s.ssds, &ssd{
-					svc: svc,
-					sds: sds,
-				})
-				return
-			}
-		}
-		panic("xhttp not exist")
+		a.readyTimeout = d
 	}
 }
 
-func NATSSDS(svc interface{}, sds ...*description.ServiceDesc) Option {
+// DrainTimeout sets how long the app waits for the in-flight requests to be
+// finished on shutdown.
+func DrainTimeout(d time.Duration) Option {
 	return func(a *app) {
-		for _, s := range a.servers {
-			if _, ok := s.server.(*xnats.Server); ok {
-				s.ssds = append(s.ssds, &ssd{
-					svc: svc,
-					sds: sds,
-				})
-				return
-			}
-		}
-		panic("xnats not exist")
+		a.drainTimeout = d
 	}
 }
 
+func WSSDS(svc interface{}, sds ...*description.ServiceDesc) Option {
+	return NamedWSSDS("", svc, sds...)
+}
+
+func TCPSDS(svc interface{}, sds ...*description.ServiceDesc) Option {
+	return NamedTCPSDS("", svc, sds...)
+}
+
+func GRPCSDS(svc interface{}, sds ...*description.ServiceDesc) Option {
+	return NamedGRPCSDS("", svc, sds...)
+}
+
+func HTTPSDS(svc interface{}, sds ...*description.ServiceDesc) Option {
+	return NamedHTTPSDS("", svc, sds...)
+}
+
+func NATSSDS(svc interface{}, sds ...*description.ServiceDesc) Option {
+	return NamedNATSSDS("", svc, sds...)
+}
+
 func CRONSDS(svc interface{}, sds ...*description.ServiceDesc) Option {
+	return NamedCRONSDS("", svc, sds...)
+}
+
+func LOCALSDS(svc interface{}, sds ...*description.ServiceDesc) Option {
+	return NamedLOCALSDS("", svc, sds...)
+}
+
+// NamedWSSDS registers services to the xws server with the given name.
+func NamedWSSDS(name string, svc interface{}, sds ...*description.ServiceDesc) Option {
 	return func(a *app) {
-		for _, s := range a.servers {
-			if _, ok := s.server.(*xcron.Server); ok {
-				s.ssds = append(s.ssds, &ssd{
-					svc: svc,
-					sds: sds,
-				})
-				return
-			}
-		}
-		panic("xcron not exist")
+		a.attach(name, "xws", svc, sds)
+	}
+}
+
+// NamedTCPSDS registers services to the xtcp server with the given name.
+func NamedTCPSDS(name string, svc interface{}, sds ...*description.ServiceDesc) Option {
+	return func(a *app) {
+		a.attach(name, "xtcp", svc, sds)
+	}
+}
+
+// NamedGRPCSDS registers services to the xgrpc server with the given name.
+func NamedGRPCSDS(name string, svc interface{}, sds ...*description.ServiceDesc) Option {
+	return func(a *app) {
+		a.attach(name, "xgrpc", svc, sds)
+	}
+}
+
+// NamedHTTPSDS registers services to the xhttp server with the given name.
+func NamedHTTPSDS(name string, svc interface{}, sds ...*description.ServiceDesc) Option {
+	return func(a *app) {
+		a.attach(name, "xhttp", svc, sds)
+	}
+}
+
+// NamedNATSSDS registers services to the xnats server with the given name.
+func NamedNATSSDS(name string, svc interface{}, sds ...*description.ServiceDesc) Option {
+	return func(a *app) {
+		a.attach(name, "xnats", svc, sds)
+	}
+}
+
+// NamedCRONSDS registers services to the xcron server with the given name.
+func NamedCRONSDS(name string, svc interface{}, sds ...*description.ServiceDesc) Option {
+	return func(a *app) {
+		a.attach(name, "xcron", svc, sds)
+	}
+}
+
+// NamedLOCALSDS registers services to the xlocal server with the given name.
+func NamedLOCALSDS(name string, svc interface{}, sds ...*description.ServiceDesc) Option {
+	return func(a *app) {
+		a.attach(name, "xlocal", svc, sds)
 	}
 }
 
 func WS(opts ...xws.Option) Option {
+	return NamedWS("", opts...)
+}
+
+func TCP(opts ...xtcp.Option) Option {
+	return NamedTCP("", opts...)
+}
+
+func NATS(opts ...xnats.Option) Option {
+	return NamedNATS("", opts...)
+}
+
+func HTTP(opts ...xhttp.Option) Option {
+	return NamedHTTP("", opts...)
+}
+
+func GRPC(opts ...xgrpc.Option) Option {
+	return NamedGRPC("", opts...)
+}
+
+func CRON(opts ...xcron.Option) Option {
+	return NamedCRON("", opts...)
+}
+
+// LOCAL adds a xlocal server, use xlocal.Attach to get client conns of it.
+func LOCAL(opts ...xlocal.Option) Option {
+	return NamedLOCAL("", opts...)
+}
+
+// NamedWS adds a xws server with the given name, so that more than one xws
+// server can be served in an app.
+func NamedWS(name string, opts ...xws.Option) Option {
 	return func(a *app) {
-		for _, s := range a.servers {
-			if _, ok := s.server.(*xws.Server); ok {
-				panic("server exists")
+		a.add(name, "xws", func() (description.Server, func(), error) {
+			if len(a.ints) > 0 {
+				opts = append([]xws.Option{xws.ChainUnaryInterceptor(a.ints...)}, opts...)
 			}
-		}
-		s := new(server)
-		s.server, s.cf = xws.New(opts...)
-		a.servers = append(a.servers, s)
+			s, cf := xws.New(opts...)
+			return s, cf, nil
+		})
 	}
 }
 
-func TCP(opts ...xtcp.Option) Option {
+// NamedTCP adds a xtcp server with the given name.
+func NamedTCP(name string, opts ...xtcp.Option) Option {
 	return func(a *app) {
-		for _, s := range a.servers {
-			if _, ok := s.server.(*xtcp.Server); ok {
-				panic("server exists")
+		a.add(name, "xtcp", func() (description.Server, func(), error) {
+			if len(a.ints) > 0 {
+				opts = append([]xtcp.Option{xtcp.ChainUnaryInterceptor(a.ints...)}, opts...)
 			}
-		}
-		s := new(server)
-		s.server, s.cf = xtcp.New(opts...)
-		a.servers = append(a.servers, s)
+			s, cf := xtcp.New(opts...)
+			return s, cf, nil
+		})
 	}
 }
 
-func NATS(opts ...xnats.Option) Option {
+// NamedNATS adds a xnats server with the given name.
+func NamedNATS(name string, opts ...xnats.Option) Option {
 	return func(a *app) {
-		for _, s := range a.servers {
-			if _, ok := s.server.(*xnats.Server); ok {
-				panic("server exists")
+		a.add(name, "xnats", func() (description.Server, func(), error) {
+			if len(a.ints) > 0 {
+				opts = append([]xnats.Option{xnats.ChainUnaryInterceptor(a.ints...)}, opts...)
 			}
-		}
-		var err error
-		s := new(server)
-		s.server, s.cf, err = xnats.New(opts...)
-		if err != nil {
-			panic(err)
-		}
-		a.servers = append(a.servers, s)
+			return xnats.New(opts...)
+		})
 	}
 }
 
-func HTTP(opts ...xhttp.Option) Option {
+// NamedHTTP adds a xhttp server with the given name.
+func NamedHTTP(name string, opts ...xhttp.Option) Option {
 	return func(a *app) {
-		for _, s := range a.servers {
-			if _, ok := s.server.(*xhttp.Server); ok {
-				panic("server exists")
+		a.add(name, "xhttp", func() (description.Server, func(), error) {
+			if len(a.ints) > 0 {
+				opts = append([]xhttp.Option{xhttp.ChainUnaryInterceptor(a.ints...)}, opts...)
 			}
-		}
-		s := new(server)
-		s.server, s.cf = xhttp.New(opts...)
-		a.servers = append(a.servers, s)
+			s, cf := xhttp.New(opts...)
+			return s, cf, nil
+		})
 	}
 }
 
-func GRPC(opts ...xgrpc.Option) Option {
+// NamedGRPC adds a xgrpc server with the given name.
+func NamedGRPC(name string, opts ...xgrpc.Option) Option {
 	return func(a *app) {
-		for _, s := range a.servers {
-			if _, ok := s.server.(*xgrpc.Server); ok {
-				panic("server exists")
+		a.add(name, "xgrpc", func() (description.Server, func(), error) {
+			if len(a.ints) > 0 {
+				opts = append([]xgrpc.Option{xgrpc.UnaryInterceptor(a.ints...)}, opts...)
 			}
-		}
-		s := new(server)
-		s.server, s.cf = xgrpc.New(opts...)
-		a.servers = append(a.servers, s)
+			s, cf := xgrpc.New(opts...)
+			return s, cf, nil
+		})
 	}
 }
 
-func CRON(opts ...xcron.Option) Option {
+// NamedCRON adds a xcron server with the given name.
+func NamedCRON(name string, opts ...xcron.Option) Option {
 	return func(a *app) {
-		for _, s := range a.servers {
-			if _, ok := s.server.(*xcron.Server); ok {
-				panic("server exists")
+		a.add(name, "xcron", func() (description.Server, func(), error) {
+			if len(a.ints) > 0 {
+				opts = append([]xcron.Option{xcron.ChainUnaryInterceptor(a.ints...)}, opts...)
 			}
+			s, cf := xcron.New(opts...)
+			return s, cf, nil
+		})
+	}
+}
+
+// NamedLOCAL adds a xlocal server with the given name.
+func NamedLOCAL(name string, opts ...xlocal.Option) Option {
+	return func(a *app) {
+		a.add(name, "xlocal", func() (description.Server, func(), error) {
+			if len(a.ints) > 0 {
+				opts = append([]xlocal.Option{xlocal.ChainUnaryInterceptor(a.ints...)}, opts...)
+			}
+			s, cf := xlocal.New(opts...)
+			return s, cf, nil
+		})
+	}
+}
+
+// add appends a server to the app, the server is built when all the options
+// are applied. It panics if a server of the same kind and name exists.
+func (a *app) add(name, kind string, build func() (description.Server, func(), error)) {
+	for _, s := range a.servers {
+		if s.name == name && s.kind == kind {
+			panic("server exists")
+		}
+	}
+	a.servers = append(a.servers, &server{
+		name:  name,
+		kind:  kind,
+		build: build,
+	})
+}
+
+// attach adds services to the server of the same kind and name.
+func (a *app) attach(name, kind string, svc interface{}, sds []*description.ServiceDesc) {
+	for _, s := range a.servers {
+		if s.name == name && s.kind == kind {
+			s.ssds = append(s.ssds, &ssd{
+				svc: svc,
+				sds: sds,
+			})
+			return
+		}
+	}
+	if name == "" {
+		panic(kind + " not exist")
+	}
+	panic(kind + " " + name + " not exist")
+}
+
+// mount mounts the xws servers with a path on the xhttp servers of the same
+// names, so that they share the port.
+func (a *app) mount() {
+	for _, s := range a.servers {
+		ws, ok := s.server.(*xws.Server)
+		if !ok || ws.Path() == "" {
+			continue
+		}
+		hs, ok := a.Server("xhttp", s.name).(*xhttp.Server)
+		if !ok {
+			panic("xhttp " + s.name + " not exist to mount xws on " + ws.Path())
 		}
-		s := new(server)
-		s.server, s.cf = xcron.New(opts...)
-		a.servers = append(a.servers, s)
+		hs.Handle(ws.Path(), ws)
+		ws.Mount(hs)
 	}
 }
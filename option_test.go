@@ -0,0 +1,68 @@
+package mo
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/xsuners/mo/net/xnats"
+	"github.com/xsuners/mo/net/xtcp"
+)
+
+func TestNamedServers(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		opts  []Option
+		panic string
+	}{
+		{"same kind different names", []Option{TCP(), NamedTCP("inner"), TCPSDS(struct{}{}, &pingDesc), NamedTCPSDS("inner", struct{}{}, &pingDesc)}, ""},
+		{"same name different kinds", []Option{NamedTCP("inner"), NamedLOCAL("inner")}, ""},
+		{"unnamed exists", []Option{TCP(), TCP()}, "server exists"},
+		{"named exists", []Option{NamedTCP("inner"), NamedTCP("inner")}, "server exists"},
+		{"unnamed not exist", []Option{NamedTCP("inner"), TCPSDS(struct{}{}, &pingDesc)}, "xtcp not exist"},
+		{"named not exist", []Option{TCP(), NamedTCPSDS("inner", struct{}{}, &pingDesc)}, "xtcp inner not exist"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if got, _ := recover().(string); got != tt.panic {
+					t.Errorf("panic = %q, want %q", got, tt.panic)
+				}
+			}()
+			New(nil, tt.opts...)
+		})
+	}
+}
+
+func TestNATSURLs(t *testing.T) {
+	defer func() {
+		if err, _ := recover().(error); err == nil || !strings.Contains(err.Error(), "urls is missing") {
+			t.Errorf("panic = %v, want missing nats urls", err)
+		}
+	}()
+	New(nil, NATS(xnats.URLS("")))
+}
+
+func TestAppServer(t *testing.T) {
+	a := New(nil, TCP(), NamedTCP("inner", xtcp.Port(0)), NamedLOCAL("inner"))
+	for _, tt := range []struct {
+		kind, name string
+		want       bool
+	}{
+		{"xtcp", "", true},
+		{"xtcp", "inner", true},
+		{"xlocal", "inner", true},
+		{"xlocal", "", false},
+		{"xtcp", "outer", false},
+		{"xws", "", false},
+	} {
+		s := a.Server(tt.kind, tt.name)
+		if got := s != nil; got != tt.want {
+			t.Errorf("Server(%q, %q) = %v, want found %v", tt.kind, tt.name, s, tt.want)
+		}
+	}
+	if a.Server("xtcp", "") == a.Server("xtcp", "inner") {
+		t.Error("servers of the same kind are the same")
+	}
+	if p := a.Server("xtcp", "inner").(*xtcp.Server).Options().Port; p != 0 {
+		t.Errorf("inner xtcp port = %d, want its own option 0", p)
+	}
+}
@@ -1,8 +1,13 @@
 package mo
 
 import (
+	"compress/flate"
+	"errors"
 	"fmt"
 	"os"
+	"reflect"
+	"strings"
+	"sync"
 
 	"github.com/skyasker/go-flags"
 	"github.com/xsuners/mo/database/xmongo"
@@ -11,6 +16,7 @@ import (
 	"github.com/xsuners/mo/database/xxorm"
 	"github.com/xsuners/mo/log"
 	"github.com/xsuners/mo/naming/consul"
+	"github.com/xsuners/mo/net/proxyproto"
 	"github.com/xsuners/mo/net/xcron"
 	"github.com/xsuners/mo/net/xgrpc"
 	"github.com/xsuners/mo/net/xgrpc/client"
@@ -30,7 +36,7 @@ type Optioner interface {
 type Options struct {
 	// Application options
 	Version bool           `json:"version" ini-name:"version" short:"v" long:"version" description:"execution version"`
-	Config  flags.Filename `json:"config" ini-name:"config" short:"c" long:"config" description:"config file path" default:"/etc/conf"`
+	Config  flags.Filename `json:"config" ini-name:"config" short:"c" long:"config" description:"config file path, ini, yaml or json" default:"/etc/conf" env:"MO_CONFIG"`
 
 	// Server options
 	Log    log.Options    `json:"log" group:"log"`
@@ -59,6 +65,12 @@ type Options struct {
 
 var _ Optioner = (*Options)(nil)
 
+// base returns the builtin options, it is promoted to the structs which embed
+// Options as well.
+func (o *Options) base() *Options {
+	return o
+}
+
 func (o *Options) V() bool {
 	return o.Version
 }
@@ -67,27 +79,167 @@ func (o *Options) C() string {
 	return string(o.Config)
 }
 
+// Validator is implemented by options which check themselves after being
+// parsed, the structs which embed Options may override it and call
+// Options.Validate in turn.
+type Validator interface {
+	Validate() error
+}
+
+// Validate reports the invalid options and combinations of them.
+func (o *Options) Validate() error {
+	var errs []string
+	ports := map[int]string{}
+	for _, p := range []struct {
+		name string
+		port int
+	}{
+		{"http", o.HTTP.Port},
+		{"grpc", o.GRPC.Port},
+		{"tcp", o.TCP.Port},
+		{"ws", o.WS.Port},
+	} {
+		if p.port < 0 || p.port > 65535 {
+			errs = append(errs, fmt.Sprintf("%s port %d out of range", p.name, p.port))
+			continue
+		}
+		if p.port == 0 { // picked by the system
+			continue
+		}
+		if p.name == "ws" && o.WS.Path != "" { // mounted on http
+			continue
+		}
+		if other, ok := ports[p.port]; ok {
+			errs = append(errs, fmt.Sprintf("%s and %s use the same port %d", other, p.name, p.port))
+			continue
+		}
+		ports[p.port] = p.name
+	}
+	// the xnats servers check their urls in turn, the options do not tell
+	// whether one is enabled
+	if o.NATS.URLs != "" || o.NATS.Credentials != "" || len(o.NATS.Subjects) > 0 {
+		if err := xnats.ValidateURLs(o.NATS.URLs); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if o.TCP.MaxConnections < 0 || o.TCP.BufferSize < 0 || o.TCP.WorkerSize < 0 || o.TCP.CompressThreshold < 0 || o.TCP.MaxFrameSize < 0 {
+		errs = append(errs, "tcp sizes must not be negative")
+	}
+	switch o.TCP.WritePolicy {
+	case "", xtcp.PolicyDropNew, xtcp.PolicyDropOldest, xtcp.PolicyBlock, xtcp.PolicyDisconnect:
+	default:
+		errs = append(errs, fmt.Sprintf("tcp write policy %q is unknown", o.TCP.WritePolicy))
+	}
+	if o.WS.CompressThreshold < 0 || o.WS.MaxMessageSize < 0 || o.WS.DeflateThreshold < 0 || o.WS.BufferSize < 0 {
+		errs = append(errs, "ws sizes must not be negative")
+	}
+	switch o.WS.WritePolicy {
+	case "", xws.PolicyDropNew, xws.PolicyDropOldest, xws.PolicyBlock, xws.PolicyDisconnect:
+	default:
+		errs = append(errs, fmt.Sprintf("ws write policy %q is unknown", o.WS.WritePolicy))
+	}
+	if o.WS.Path != "" && !strings.HasPrefix(o.WS.Path, "/") {
+		errs = append(errs, fmt.Sprintf("ws path %q must start with /", o.WS.Path))
+	}
+	if o.WS.DeflateLevel < flate.HuffmanOnly || o.WS.DeflateLevel > flate.BestCompression {
+		errs = append(errs, fmt.Sprintf("ws deflate level %d out of range", o.WS.DeflateLevel))
+	}
+	if o.TCP.HeartbeatInterval < 0 || o.TCP.IdleTimeout < 0 || o.TCP.PongTimeout < 0 || o.WS.IdleTimeout < 0 {
+		errs = append(errs, "keepalive durations must not be negative")
+	}
+	if (o.TCP.TLSCert == "") != (o.TCP.TLSKey == "") {
+		errs = append(errs, "tcp tls cert and key must be set together")
+	}
+	if o.TCP.TLSCert == "" && (o.TCP.TLSClientCA != "" || o.TCP.TLSRequireClientCert) {
+		errs = append(errs, "tcp tls client CA is set without tls cert")
+	}
+	if o.TCP.TLSRequireClientCert && o.TCP.TLSClientCA == "" {
+		errs = append(errs, "tcp tls client CA is missing to require client certs")
+	}
+	if o.TCP.HandshakeTimeout < 0 {
+		errs = append(errs, "tcp handshake timeout must not be negative")
+	}
+	for _, p := range []struct{ name, cidrs string }{
+		{"http", o.HTTP.ProxyProtocol},
+		{"tcp", o.TCP.ProxyProtocol},
+		{"ws", o.WS.ProxyProtocol},
+	} {
+		if _, err := proxyproto.ParseCIDRs(p.cidrs); err != nil {
+			errs = append(errs, fmt.Sprintf("%s proxy protocol %v", p.name, err))
+		}
+	}
+	if o.Log.Level < log.LevelDebug || o.Log.Level > log.LevelFatal {
+		errs = append(errs, fmt.Sprintf("log level %d out of range", o.Log.Level))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("mo: invalid options: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Parse parses the options from the config file, the environment variables
+// and the command line flags, see load for the order, and validates them
+// before any server starts. It exits the process on error.
 func Parse(option Optioner) {
 	parser := flags.NewParser(option, flags.Default|flags.IgnoreUnknown)
-	ini := flags.NewIniParser(parser)
-	if _, err := parser.Parse(); err != nil {
-		switch flagsErr := err.(type) {
-		case flags.ErrorType:
-			if flagsErr == flags.ErrHelp {
-				os.Exit(0)
-			}
+	if err := load(parser, option, os.Args[1:]); err != nil {
+		if flags.WroteHelp(err) {
+			os.Exit(0)
+		}
+		if _, ok := err.(*flags.Error); !ok { // printed by the parser already
 			fmt.Println(err)
-			os.Exit(1)
-		default:
-			os.Exit(1)
 		}
+		os.Exit(1)
 	}
 	if option.V() {
 		fmt.Print(BuildInfo())
 		os.Exit(0)
 	}
-	if err := ini.ParseFile(option.C()); err != nil {
+	if err := validate(option); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	setParsed(option)
+}
+
+func validate(option Optioner) error {
+	if v, ok := option.(Validator); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
+var (
+	mu     sync.Mutex
+	parsed Optioner // the last parsed options
+)
+
+func setParsed(option Optioner) {
+	mu.Lock()
+	parsed = option
+	mu.Unlock()
+}
+
+// reparse parses the options again into a new value of the
+// same type as the last parsed options, which are left untouched.
+func reparse() (Optioner, error) {
+	mu.Lock()
+	last := parsed
+	mu.Unlock()
+	if last == nil {
+		return nil, errors.New("mo: options are not parsed")
+	}
+	t := reflect.TypeOf(last)
+	if t.Kind() != reflect.Ptr {
+		return nil, fmt.Errorf("mo: options type (%s) is not a pointer", t)
+	}
+	option := reflect.New(t.Elem()).Interface().(Optioner)
+	parser := flags.NewParser(option, flags.IgnoreUnknown)
+	if err := load(parser, option, os.Args[1:]); err != nil {
+		return nil, err
+	}
+	if err := validate(option); err != nil {
+		return nil, err
+	}
+	return option, nil
 }
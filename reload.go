@@ -0,0 +1,108 @@
+package mo
+
+import (
+	"fmt"
+
+	"github.com/xsuners/mo/log"
+	"github.com/xsuners/mo/net/xcron"
+	"github.com/xsuners/mo/net/xhttp"
+	"github.com/xsuners/mo/net/xnats"
+	"github.com/xsuners/mo/net/xtcp"
+	"go.uber.org/zap"
+)
+
+// ReloadFunc is called with the newly parsed options when the config is
+// reloaded, the options are of the same type as the ones passed to Parse.
+type ReloadFunc func(Optioner) error
+
+// reload parses the flags and the config file again and applies the new
+// options. The old options are kept if parsing fails or any of the components
+// refuses the new options.
+func (a *app) reload() {
+	opt, err := reparse()
+	if err != nil {
+		log.Errors("mo: reload config error, keep the old config", zap.Error(err))
+		return
+	}
+	if err = a.update(opt); err != nil {
+		log.Errors("mo: reload config error, keep the old config", zap.Error(err))
+		return
+	}
+	setParsed(opt)
+	log.Infos("mo: config reloaded")
+}
+
+// update applies opt in two phases: the reloads of the builtin options are
+// prepared, then the reload callbacks are called, and the prepared reloads
+// are committed only if all of them succeed, aborted otherwise.
+func (a *app) update(opt Optioner) error {
+	var prepared []prepared
+	if b, is := opt.(interface{ base() *Options }); is {
+		var err error
+		if prepared, err = a.prepare(b.base()); err != nil {
+			return err
+		}
+	}
+	for _, fn := range a.reloaders {
+		if err := fn(opt); err != nil {
+			abort(prepared)
+			return fmt.Errorf("mo: reload callback error: %w", err)
+		}
+	}
+	for _, p := range prepared {
+		p.commit()
+	}
+	return nil
+}
+
+// prepared is a reload checked but not applied yet.
+type prepared struct {
+	commit func()
+	abort  func()
+}
+
+// prepare prepares the reloads of the reloadable builtin options of the
+// logger and the unnamed servers, the named ones are up to the reload
+// callbacks. The prepared ones are aborted if any of them fails.
+func (a *app) prepare(o *Options) (ps []prepared, err error) {
+	defer func() {
+		if err != nil {
+			abort(ps)
+		}
+	}()
+	if o.Log.Level < log.LevelDebug || o.Log.Level > log.LevelFatal {
+		return ps, fmt.Errorf("mo: log level %d out of range", o.Log.Level)
+	}
+	level := o.Log.Level
+	ps = append(ps, prepared{commit: func() { log.SetLevel(level) }, abort: func() {}})
+	for _, s := range a.servers {
+		if s.name != "" {
+			continue
+		}
+		var commit, abort func()
+		switch srv := s.server.(type) {
+		case *xtcp.Server:
+			commit, abort, err = srv.PrepareReload(o.TCP)
+		case *xhttp.Server:
+			commit, abort, err = srv.PrepareReload(o.HTTP)
+		case *xcron.Server:
+			commit, abort, err = srv.PrepareReload(o.CRON)
+		case *xnats.Server:
+			commit, abort, err = srv.PrepareReload(o.NATS)
+		default:
+			continue
+		}
+		if err != nil {
+			return ps, fmt.Errorf("mo: reload %s error: %w", s.kind, err)
+		}
+		ps = append(ps, prepared{commit: commit, abort: abort})
+	}
+	return ps, nil
+}
+
+// abort discards the prepared reloads.
+func abort(ps []prepared) {
+	for i := len(ps) - 1; i >= 0; i-- {
+		ps[i].abort()
+	}
+}
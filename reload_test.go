@@ -0,0 +1,77 @@
+package mo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nats-io/nats-server/v2/test"
+	"github.com/xsuners/mo/log"
+	"github.com/xsuners/mo/net/description"
+	"github.com/xsuners/mo/net/xhttp"
+	"github.com/xsuners/mo/net/xnats"
+	"github.com/xsuners/mo/net/xtcp"
+)
+
+type pinger interface{}
+
+var pingDesc = description.ServiceDesc{
+	ServiceName: "test.Ping",
+	HandlerType: (*pinger)(nil),
+	Methods: []description.MethodDesc{
+		{
+			MethodName: "Ping",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor description.UnaryServerInterceptor) (interface{}, error) {
+				return nil, nil
+			},
+			Input: "test.ping",
+		},
+	},
+}
+
+func TestReloadKeepsOldConfig(t *testing.T) {
+	ns := test.RunRandClientPortServer()
+	t.Cleanup(ns.Shutdown)
+	defer log.SetLevel(log.GetLevel())
+	log.SetLevel(log.LevelInfo)
+
+	a := New(nil,
+		TCP(xtcp.Port(0)),
+		HTTP(xhttp.Port(0)),
+		NATS(xnats.URLS(ns.ClientURL())),
+	).(*app)
+	nsrv := a.Server("xnats", "").(*xnats.Server)
+	nsrv.Register(struct{}{}, &pingDesc)
+	if err := nsrv.Serve(); err != nil {
+		t.Fatalf("Serve() error = %v", err)
+	}
+	defer nsrv.Stop()
+	tcp := a.Server("xtcp", "").(*xtcp.Server)
+	hs := a.Server("xhttp", "").(*xhttp.Server)
+	oldTCP, oldHTTP := tcp.Options(), hs.Options()
+
+	o := &Options{}
+	o.Log.Level = log.LevelError
+	o.TCP.MaxConnections = oldTCP.MaxConnections + 1
+	o.HTTP.CorsOrigins = "https://example.com"
+	o.NATS.Subjects = map[string]string{"test.Ping/Ping": "bad subject"}
+	if err := a.update(o); err == nil {
+		t.Fatal("update() error = nil, want the nats subject error")
+	}
+	if got := tcp.Options().MaxConnections; got != oldTCP.MaxConnections {
+		t.Errorf("tcp max connections = %d, want the old %d", got, oldTCP.MaxConnections)
+	}
+	if got := hs.Options().CorsOrigins; got != oldHTTP.CorsOrigins {
+		t.Errorf("http cors origins = %q, want the old %q", got, oldHTTP.CorsOrigins)
+	}
+	if got := log.GetLevel(); got != log.LevelInfo {
+		t.Errorf("log level = %d, want the old %d", got, log.LevelInfo)
+	}
+
+	o.NATS.Subjects = map[string]string{"test.Ping/Ping": "test.ping2"}
+	if err := a.update(o); err != nil {
+		t.Fatalf("update() error = %v", err)
+	}
+	if tcp.Options().MaxConnections != o.TCP.MaxConnections || hs.Options().CorsOrigins != o.HTTP.CorsOrigins || log.GetLevel() != log.LevelError {
+		t.Error("new config is not applied")
+	}
+}
@@ -16,7 +16,7 @@ func NewInt64(initialValue int64) *Int64 {
 
 // Get returns the value of int64 atomically.
 func (a *Int64) Get() int64 {
-	return int64(*a)
+	return atomic.LoadInt64((*int64)(a))
 }
 
 // Set sets the value of int64 atomically.